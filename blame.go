@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// writeSourceMap writes the source map of the formatted output to path.
+func writeSourceMap(path string, sources combiner.SourceIndex, merged *jwcc.Object, formatted []byte) error {
+	sm, err := sources.SourceMap(merged, formatted)
+	if err != nil {
+		return err
+	}
+	sm.File = *outFile
+
+	b, err := json.MarshalIndent(sm, "", "  ")
+	if err != nil {
+		return err
+	}
+	logVerbose("writing source map [%v]...\n", path)
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// readSourceMap reads a source map written by -sourcemap.
+func readSourceMap(path string) (*combiner.SourceMap, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sm := &combiner.SourceMap{}
+	err = json.Unmarshal(b, sm)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return sm, nil
+}
+
+// runBlame prints the file, line and column each value on a line of the
+// output came from, using the source map written by -sourcemap.
+func runBlame(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("blame", flag.ContinueOnError)
+	sourceMapFile := flags.String("sourcemap", "", "source map written by -sourcemap")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: tailscale-acl-combiner blame -sourcemap <file> <line>\n")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *sourceMapFile == "" {
+		return errors.New("missing argument -sourcemap - the source map written by -sourcemap must be provided")
+	}
+	if flags.NArg() != 1 {
+		return errors.New("missing argument <line> - a line of the output must be provided")
+	}
+	line, err := strconv.Atoi(flags.Arg(0))
+	if err != nil || line < 1 {
+		return fmt.Errorf("invalid line [%s], expected a line number like [412]", flags.Arg(0))
+	}
+
+	sm, err := readSourceMap(*sourceMapFile)
+	if err != nil {
+		return err
+	}
+	mappings := sm.Lookup(line)
+	if len(mappings) == 0 {
+		return fmt.Errorf("no source found for line [%d] in [%s]", line, *sourceMapFile)
+	}
+	for _, m := range mappings {
+		fmt.Fprintf(w, "%s in [%s]\n", m, m.Section)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const BLAME_SOURCE_MAP = `{
+  "file": "policy.hujson",
+  "mappings": [
+    {"startLine": 2, "endLine": 12, "section": "acls", "source": "policy-parent.hujson", "line": 2, "column": 2},
+    {"startLine": 3, "endLine": 7, "section": "acls", "source": "departments/finance/acls.hujson", "line": 3, "column": 3},
+    {"startLine": 5, "endLine": 5, "section": "acls", "source": "departments/finance/acls.hujson", "line": 6, "column": 4}
+  ]
+}`
+
+func TestRunBlame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sourcemap.json")
+	err := os.WriteFile(path, []byte(BLAME_SOURCE_MAP), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"5", "departments/finance/acls.hujson:6:4 in [acls]\n"},
+		{"6", "departments/finance/acls.hujson:3:3 in [acls]\n"},
+		{"10", "policy-parent.hujson:2:2 in [acls]\n"},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		err := runBlame([]string{"-sourcemap", path, tt.line}, &sb)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		if sb.String() != tt.expected {
+			t.Errorf("line [%s] should be from [%s], got [%s]", tt.line, tt.expected, sb.String())
+		}
+	}
+
+	err = runBlame([]string{"-sourcemap", path, "20"}, &strings.Builder{})
+	if err == nil || !strings.Contains(err.Error(), "no source found for line [20]") {
+		t.Fatalf("expected no source for line [20], got [%v]", err)
+	}
+	err = runBlame([]string{"-sourcemap", path, "x"}, &strings.Builder{})
+	if err == nil || !strings.Contains(err.Error(), "invalid line [x]") {
+		t.Fatalf("expected invalid line, got [%v]", err)
+	}
+	err = runBlame([]string{"5"}, &strings.Builder{})
+	if err == nil || !strings.Contains(err.Error(), "missing argument -sourcemap") {
+		t.Fatalf("expected missing argument, got [%v]", err)
+	}
+}
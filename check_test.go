@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestUnifiedDiffSame(t *testing.T) {
+	diff := unifiedDiff("a", "b", []byte("1\n2\n3\n"), []byte("1\n2\n3\n"))
+	if diff != "" {
+		t.Fatalf("expected no diff, got [%v]", diff)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b := "1\n2\nx\n4\n5\n6\n7\n8\n9\n10\n11\n"
+
+	expected := `--- a
++++ b
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++x
+ 4
+ 5
+ 6
+@@ -9,4 +9,3 @@
+ 9
+ 10
+ 11
+-12
+`
+
+	diff := unifiedDiff("a", "b", []byte(a), []byte(b))
+	if diff != expected {
+		t.Fatalf("expected diff [%v], got [%v]", expected, diff)
+	}
+}
+
+func TestDiffLinesRebuildsBoth(t *testing.T) {
+	a, b := []string{}, []string{}
+	for i := 0; i < 500; i++ {
+		a = append(a, fmt.Sprintf("%d\n", i))
+		if i%3 != 0 {
+			b = append(b, fmt.Sprintf("%d\n", i*7%500))
+		}
+	}
+
+	gotA, gotB := []string{}, []string{}
+	for _, op := range diffLines(a, b) {
+		if op.kind != '+' {
+			gotA = append(gotA, op.line)
+		}
+		if op.kind != '-' {
+			gotB = append(gotB, op.line)
+		}
+	}
+	if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+		t.Fatalf("expected the edit script to rebuild both inputs")
+	}
+}
+
+func TestUnifiedDiffMissingNewline(t *testing.T) {
+	expected := `--- a
++++ b
+@@ -1,2 +1,2 @@
+ 1
+-2
++2
+\ No newline at end of file
+`
+
+	diff := unifiedDiff("a", "b", []byte("1\n2\n"), []byte("1\n2"))
+	if diff != expected {
+		t.Fatalf("expected diff [%v], got [%v]", expected, diff)
+	}
+}
+
+func TestUnifiedDiffEmpty(t *testing.T) {
+	expected := `--- a
++++ b
+@@ -0,0 +1,2 @@
++1
++2
+`
+
+	diff := unifiedDiff("a", "b", []byte(""), []byte("1\n2\n"))
+	if diff != expected {
+		t.Fatalf("expected diff [%v], got [%v]", expected, diff)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.hujson")
+	err := os.WriteFile(path, []byte("{\n\t\"acls\": [],\n}\n"), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	diff, err := checkFile(path, []byte("{\n\t\"acls\": [],\n}\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if diff != "" {
+		t.Fatalf("expected no diff, got [%v]", diff)
+	}
+
+	diff, err = checkFile(path, []byte("{\n\t\"acls\": [],\n\t\"ssh\": [],\n}\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if diff == "" {
+		t.Fatalf("expected diff, got none")
+	}
+}
+
+func TestCheckFileMissing(t *testing.T) {
+	_, err := checkFile(filepath.Join(t.TempDir(), "missing.hujson"), []byte("{}"))
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+}
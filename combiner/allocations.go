@@ -0,0 +1,208 @@
+package combiner
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// allocates reports whether addrs is inside one of the rule's prefixes.
+func (r PathRule) allocates(addrs addrRange) bool {
+	for _, p := range r.Prefixes {
+		allocation, _ := parseAddrRange(p.String())
+		if allocation.contains(addrs) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PathRule) describePrefixes() string {
+	prefixes := []string{}
+	for _, p := range r.Prefixes {
+		prefixes = append(prefixes, p.String())
+	}
+	return strings.Join(prefixes, ",")
+}
+
+// checkAllocations rejects autoApprovers routes and ipsets entries outside the
+// prefixes allocated to the most specific PathRule with prefixes matching the
+// child.
+func checkAllocations(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) Diagnostics {
+	rule := ctx.ruleFor(func(r PathRule) bool { return len(r.Prefixes) > 0 })
+	if rule == nil {
+		return nil
+	}
+	obj, ok := childSection.Value.(*jwcc.Object)
+	if !ok {
+		return nil
+	}
+
+	diags := Diagnostics{}
+	switch sectionKey {
+	case "autoApprovers":
+		routes := routeMembers(obj)
+		for _, m := range routes {
+			route, err := netip.ParsePrefix(m.Key.String())
+			if err != nil {
+				diags = append(diags, diagnosticAt(ctx.ChildPath, m, RuleAllocation, "invalid route [%s]: %v", m.Key, err))
+				continue
+			}
+			addrs, _ := parseAddrRange(route.String())
+			if !rule.allocates(addrs) {
+				diags = append(diags, diagnosticAt(ctx.ChildPath, m, RuleAllocation, "route [%s] is outside the allocation [%s] of [%s]", m.Key, rule.describePrefixes(), rule.Pattern))
+			}
+		}
+
+	case "ipsets":
+		for _, m := range obj.Members {
+			for _, d := range stringValues(m.Value) {
+				entry := d.Value.(ast.Text).String()
+				// removing addresses can't take an ipset outside its allocation
+				if strings.HasPrefix(entry, "remove ") {
+					continue
+				}
+				name := strings.TrimSpace(strings.TrimPrefix(entry, "add "))
+				if addrs, ok := parseAddrRange(name); ok {
+					if !rule.allocates(addrs) {
+						diags = append(diags, diagnosticAt(ctx.ChildPath, d, RuleAllocation, "ipset entry [%s] in [%s] is outside the allocation [%s] of [%s]", entry, m.Key, rule.describePrefixes(), rule.Pattern))
+					}
+					continue
+				}
+
+				resolved, ok := ctx.ipsetAddresses(parent, name, map[string]bool{})
+				if !ok {
+					warning := diagnosticAt(ctx.ChildPath, d, RuleAllocation, "ipset entry [%s] in [%s] can't be resolved to addresses, so it isn't checked against the allocation [%s] of [%s]", entry, m.Key, rule.describePrefixes(), rule.Pattern)
+					warning.Severity = SeverityWarning
+					diags = append(diags, warning)
+					continue
+				}
+				for _, addrs := range resolved {
+					if !rule.allocates(addrs) {
+						diags = append(diags, diagnosticAt(ctx.ChildPath, d, RuleAllocation, "ipset entry [%s] in [%s] resolves to [%s], outside the allocation [%s] of [%s]", entry, m.Key, addrs, rule.describePrefixes(), rule.Pattern))
+						break
+					}
+				}
+			}
+		}
+	}
+	return diags
+}
+
+// ipsetAddresses resolves name, a host, "host:" or "ipset:" reference in an
+// ipsets entry, to the addresses it adds, using the hosts and ipsets of the
+// child, then those merged into parent before it. It returns false if a
+// reference can't be resolved.
+func (c *MergeContext) ipsetAddresses(parent *jwcc.Object, name string, seen map[string]bool) ([]addrRange, bool) {
+	if addrs, ok := parseAddrRange(name); ok {
+		return []addrRange{addrs}, true
+	}
+	if seen[name] {
+		return nil, true
+	}
+	seen[name] = true
+
+	section, key := "hosts", strings.TrimPrefix(name, "host:")
+	if strings.HasPrefix(name, "ipset:") {
+		section, key = "ipsets", name
+	}
+	var definition *jwcc.Member
+	for _, obj := range []*jwcc.Object{c.child, parent} {
+		if definition = findDefinition(obj, section, key); definition != nil {
+			break
+		}
+	}
+	if definition == nil {
+		return nil, false
+	}
+
+	resolved := []addrRange{}
+	for _, d := range stringValues(definition.Value) {
+		entry := d.Value.(ast.Text).String()
+		if strings.HasPrefix(entry, "remove ") {
+			continue
+		}
+		addrs, ok := c.ipsetAddresses(parent, strings.TrimSpace(strings.TrimPrefix(entry, "add ")), seen)
+		if !ok {
+			return nil, false
+		}
+		resolved = append(resolved, addrs...)
+	}
+	return resolved, true
+}
+
+// findDefinition returns the member named key in the object section of obj,
+// or nil if there is none.
+func findDefinition(obj *jwcc.Object, section string, key string) *jwcc.Member {
+	if obj == nil {
+		return nil
+	}
+	m := obj.FindKey(ast.TextEqual(section))
+	if m == nil {
+		return nil
+	}
+	sectionObj, ok := m.Value.(*jwcc.Object)
+	if !ok {
+		return nil
+	}
+	return sectionObj.FindKey(ast.TextEqual(key))
+}
+
+// checkRouteOverlaps rejects autoApprovers routes overlapping a route already
+// merged from another child, once any rule has prefixes. Without allocations,
+// routes from several children are combined by the conflict strategy.
+func checkRouteOverlaps(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) Diagnostics {
+	if sectionKey != "autoApprovers" || !slices.ContainsFunc(ctx.rules, func(r PathRule) bool { return len(r.Prefixes) > 0 }) {
+		return nil
+	}
+	childObj, ok := childSection.Value.(*jwcc.Object)
+	if !ok {
+		return nil
+	}
+	parentSection := parent.Find(sectionKey)
+	if parentSection == nil {
+		return nil
+	}
+	parentObj, ok := parentSection.Value.(*jwcc.Object)
+	if !ok {
+		return nil
+	}
+
+	diags := Diagnostics{}
+	for _, m := range routeMembers(childObj) {
+		childRoute, ok := parseAddrRange(m.Key.String())
+		if !ok {
+			continue
+		}
+
+		for _, existing := range routeMembers(parentObj) {
+			existingRoute, ok := parseAddrRange(existing.Key.String())
+			if !ok || !childRoute.overlaps(existingRoute) {
+				continue
+			}
+			for _, source := range sourcesFromComments(existing.Comments().Before) {
+				if source != ctx.ParentPath && source != ctx.ChildPath {
+					diags = append(diags, diagnosticAt(ctx.ChildPath, m, RuleRouteOverlap, "route [%s] overlaps route [%s] from [%s]", m.Key, existing.Key, source))
+					break
+				}
+			}
+		}
+	}
+	return diags
+}
+
+// routeMembers returns the routes in an autoApprovers object.
+func routeMembers(autoApprovers *jwcc.Object) []*jwcc.Member {
+	routes := autoApprovers.FindKey(ast.TextEqual("routes"))
+	if routes == nil {
+		return nil
+	}
+	obj, ok := routes.Value.(*jwcc.Object)
+	if !ok {
+		return nil
+	}
+	return obj.Members
+}
@@ -0,0 +1,163 @@
+package combiner
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestAllocations(t *testing.T) {
+	rule := PathRule{
+		Pattern:  "departments/finance",
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.10.0/24"), netip.MustParsePrefix("192.0.2.0/28")},
+	}
+
+	err := mergeChildWithRule(t, "departments/finance/policy.hujson", `{
+		"autoApprovers": {"routes": {"10.0.10.0/25": ["tag:finance"], "10.0.10.128/32": ["tag:finance"]}},
+		"ipsets": {"ipset:finance": ["192.0.2.1", "192.0.2.2-192.0.2.15", "add 10.0.10.0/24", "remove 10.0.0.0/8"]},
+	}`, rule)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	err = mergeChildWithRule(t, "departments/finance/policy.hujson", `{
+		"autoApprovers": {"routes": {"10.0.0.0/16": ["tag:finance"], "not-a-route": []}},
+		"ipsets": {"ipset:finance": ["192.0.2.16", "192.0.2.0-192.0.2.20", "host-name"]},
+	}`, rule)
+
+	diags := AsDiagnostics(err)
+	expected := []string{
+		"departments/finance/policy.hujson:2:32: route [10.0.0.0/16] is outside the allocation [10.0.10.0/24,192.0.2.0/28] of [departments/finance]",
+		"departments/finance/policy.hujson:2:64: invalid route [not-a-route]: netip.ParsePrefix(\"not-a-route\"): no '/'",
+		"departments/finance/policy.hujson:3:32: ipset entry [192.0.2.16] in [ipset:finance] is outside the allocation [10.0.10.0/24,192.0.2.0/28] of [departments/finance]",
+		"departments/finance/policy.hujson:3:46: ipset entry [192.0.2.0-192.0.2.20] in [ipset:finance] is outside the allocation [10.0.10.0/24,192.0.2.0/28] of [departments/finance]",
+		"departments/finance/policy.hujson:3:70: ipset entry [host-name] in [ipset:finance] can't be resolved to addresses, so it isn't checked against the allocation [10.0.10.0/24,192.0.2.0/28] of [departments/finance]",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), err)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] || d.Rule != RuleAllocation {
+			t.Errorf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+	if diags[4].Severity != SeverityWarning {
+		t.Errorf("an entry that can't be resolved should be a warning, got [%s]", diags[4].Severity)
+	}
+}
+
+func TestAllocationsResolveReferences(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {"db": "10.0.10.5", "other": "10.9.0.1"},
+		"ipsets": {"ipset:shared": ["10.0.10.6", "host:db"]},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"ipsets": {"ipset:finance": ["host:db", "ipset:shared", "host:local", "host:other", "host:missing"]},
+		"hosts": {"local": "10.0.10.7"},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	sections, err := DefaultRegistry().Allow([]string{"hosts", "ipsets"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	err = Merge(&ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"}, []*ParsedDocument{
+		{Object: child.Value.(*jwcc.Object), Path: "finance.hujson"},
+	}, Options{Sections: sections, Rules: []PathRule{
+		{Pattern: "finance.hujson", Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.10.0/24")}},
+	}})
+
+	diags := AsDiagnostics(err)
+	expected := []string{
+		"finance.hujson:2:73: ipset entry [host:other] in [ipset:finance] resolves to [10.9.0.1], outside the allocation [10.0.10.0/24] of [finance.hujson]",
+		"finance.hujson:2:87: ipset entry [host:missing] in [ipset:finance] can't be resolved to addresses, so it isn't checked against the allocation [10.0.10.0/24] of [finance.hujson]",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), err)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] {
+			t.Errorf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+	if !diags.HasErrors() || diags[1].Severity != SeverityWarning {
+		t.Errorf("expected an error for [host:other] and a warning for [host:missing], got [%v]", diags)
+	}
+}
+
+func TestRouteOverlaps(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"autoApprovers": {"routes": {"10.0.0.0/8": ["group:it"]}},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	engineering, err := jwcc.Parse(strings.NewReader(`{
+		"autoApprovers": {"routes": {"10.0.0.0/24": ["tag:engineering"]}},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	finance, err := jwcc.Parse(strings.NewReader(`{
+		"autoApprovers": {"routes": {"10.0.0.128/25": ["tag:finance"], "10.0.10.0/24": ["tag:finance"]}},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	sections, err := DefaultRegistry().Allow([]string{"autoApprovers"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	err = Merge(&ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"}, []*ParsedDocument{
+		{Object: engineering.Value.(*jwcc.Object), Path: "engineering.hujson"},
+		{Object: finance.Value.(*jwcc.Object), Path: "finance.hujson"},
+	}, Options{Sections: sections, Rules: []PathRule{
+		{Pattern: "engineering.hujson", Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}},
+		{Pattern: "finance.hujson", Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")}},
+	}})
+
+	diags := AsDiagnostics(err)
+	expected := "finance.hujson:2:32: route [10.0.0.128/25] overlaps route [10.0.0.0/24] from [engineering.hujson]"
+	if len(diags) != 1 || diags[0].Error() != expected || diags[0].Rule != RuleRouteOverlap {
+		t.Fatalf("expected [%s], got [%v]", expected, err)
+	}
+}
+
+func TestRouteOverlapsWithoutAllocations(t *testing.T) {
+	// without allocations, the same route from two children is combined by the union strategy
+	children := []*ParsedDocument{}
+	for _, path := range []string{"engineering.hujson", "finance.hujson"} {
+		child, err := jwcc.Parse(strings.NewReader(`{
+			"autoApprovers": {"routes": {"10.0.0.0/24": ["tag:` + strings.TrimSuffix(path, ".hujson") + `"]}},
+		}`))
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		children = append(children, &ParsedDocument{Object: child.Value.(*jwcc.Object), Path: path})
+	}
+
+	sections, err := DefaultRegistry().Allow([]string{"autoApprovers"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parent := &ParsedDocument{Object: &jwcc.Object{}, Path: "parent"}
+	err = Merge(parent, children, Options{Sections: sections})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	routes := parent.Object.Find("autoApprovers").Value.(*jwcc.Object).Find("routes").Value.(*jwcc.Object)
+	approvers := routes.Find("10.0.0.0/24").Value.(*jwcc.Array).Values
+	if len(approvers) != 2 {
+		t.Fatalf("expected the approvers to be combined, got [%v]", len(approvers))
+	}
+}
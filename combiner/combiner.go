@@ -0,0 +1,173 @@
+// Package combiner merges a parent Tailscale policy file with sections from
+// child policy files, preserving comments and recording which file each
+// entry came from.
+//
+// A typical use parses the parent, gathers the children from a directory,
+// merges them and formats the result:
+//
+//	parent, err := combiner.Parse("policy-parent.hujson")
+//	children, err := combiner.GatherChildren("departments", opts)
+//	err = combiner.Merge(parent, children, opts)
+//	out, err := combiner.Format(parent.Object)
+//
+// The functions checking a merged policy, such as EvaluateTests and
+// CheckReferences, report each problem in the parent or child file the value
+// came from, which they find from the provenance comments added by Merge.
+package combiner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/jtree"
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale/hujson"
+)
+
+// A ParsedDocument is a policy file and the path it was read from.
+type ParsedDocument struct {
+	Path   string
+	Object *jwcc.Object
+
+	// Root is the directory GatherChildren found the document under, or empty.
+	// PathRule patterns are matched relative to it.
+	Root string
+}
+
+// rulePaths returns the paths PathRule patterns are matched against: the
+// document's path relative to Root, and the same path including the name of
+// Root, so "departments/finance" and "finance" both match a file under
+// departments/finance when gathered from "testdata/departments". Without
+// Root, it's the document's path.
+func (d *ParsedDocument) rulePaths() []string {
+	if d.Root == "" {
+		return []string{d.Path}
+	}
+	rel, err := filepath.Rel(d.Root, d.Path)
+	if err != nil {
+		return []string{d.Path}
+	}
+	paths := []string{rel}
+	name := filepath.Base(filepath.Clean(d.Root))
+	if name != "." && name != ".." && name != string(filepath.Separator) {
+		paths = append(paths, filepath.Join(name, rel))
+	}
+	return paths
+}
+
+// Options configure GatherChildren and Merge.
+type Options struct {
+	// Sections are the handlers for the sections allowed from child files.
+	Sections Registry
+
+	// Rules allow a different set of sections for child files under matching
+	// paths. For each of a rule's controls, the most specific matching rule
+	// that sets it wins.
+	Rules []PathRule
+
+	// Logf, if set, receives verbose progress messages.
+	Logf func(format string, args ...any)
+}
+
+func (o Options) logf(format string, args ...any) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+// Parse reads the policy file at path. The document root must be an object.
+// Problems with the file are returned as a Diagnostic.
+func Parse(path string) (*ParsedDocument, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			return nil, Diagnostic{Path: path, Severity: SeverityError, Rule: RuleReadFile, Message: pathErr.Err.Error()}
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	doc, err := jwcc.Parse(f)
+	if err != nil {
+		var syntaxErr *jtree.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, Diagnostic{
+				Path:     path,
+				Line:     syntaxErr.Location.Line,
+				Column:   syntaxErr.Location.Column + 1,
+				Severity: SeverityError,
+				Rule:     RuleParse,
+				Message:  fmt.Sprintf("error parsing: %s", syntaxErr.Message),
+			}
+		}
+		return nil, Diagnostic{Path: path, Severity: SeverityError, Rule: RuleParse, Message: fmt.Sprintf("error parsing: %v", err)}
+	}
+
+	root, ok := doc.Value.(*jwcc.Object)
+	if !ok {
+		return nil, diagnosticAt(path, doc.Value, RuleInvalidType, "invalid file format: document root is [%s], expected [object]", valueKind(doc.Value))
+	}
+
+	return &ParsedDocument{Path: path, Object: root}, nil
+}
+
+// GatherChildren parses every .json and .hujson file under dir, with dir as
+// their Root. Files that fail to parse are skipped and reported together as
+// Diagnostics, along with the files that parsed successfully.
+func GatherChildren(dir string, opts Options) ([]*ParsedDocument, error) {
+	children := []*ParsedDocument{}
+	diags := Diagnostics{}
+
+	opts.logf("walking path [%v]...\n", dir)
+	err := filepath.WalkDir(
+		dir,
+		func(path string, info fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			if !strings.HasSuffix(path, ".json") && !strings.HasSuffix(path, ".hujson") {
+				return nil
+			}
+
+			opts.logf("parsing [%v]...\n", path)
+			doc, err := Parse(path)
+			if err != nil {
+				diags = append(diags, AsDiagnostics(err)...)
+				return nil
+			}
+
+			doc.Root = dir
+			children = append(children, doc)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(diags) > 0 {
+		return children, diags
+	}
+	return children, nil
+}
+
+// Format renders doc as HuJSON, formatted with hujson.Format.
+func Format(doc *jwcc.Object) ([]byte, error) {
+	var sb strings.Builder
+	err := jwcc.Format(&sb, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return hujson.Format([]byte(sb.String()))
+}
@@ -0,0 +1,208 @@
+package combiner
+
+import (
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// HandleDERPMap merges the Regions of a child's derpMap into the parent's by
+// region ID, and the Nodes of a region by name. A region may be defined by the
+// parent and extended by children, but a region a child defines can't be
+// defined by another child. OmitDefaultRegions is only taken from the parent -
+// https://tailscale.com/kb/1118/custom-derp-servers
+func HandleDERPMap() SectionHandler {
+	return func(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+
+		childObj, ok := childSection.Value.(*jwcc.Object)
+		if !ok {
+			return ctx.Errorf(childSection, RuleInvalidType, "section [%s] must be an object, got %s", sectionKey, valueKind(childSection.Value))
+		}
+
+		derpMap, err := existingOrNewObject(ctx, *parent, sectionKey)
+		if err != nil {
+			return err
+		}
+
+		if childObj.Find("OmitDefaultRegions") != nil {
+			ctx.Logf("ignoring [OmitDefaultRegions] in section [%s] from [%s], only the parent's is used\n", sectionKey, ctx.ChildPath)
+		}
+
+		childRegions := childObj.Find("Regions")
+		if childRegions == nil {
+			upsertMember(parent, sectionKey, derpMap)
+			return nil
+		}
+		childRegionsObj, ok := childRegions.Value.(*jwcc.Object)
+		if !ok {
+			return ctx.Errorf(childRegions, RuleInvalidType, "[%s.Regions] must be an object, got %s", sectionKey, valueKind(childRegions.Value))
+		}
+
+		regions, err := derpRegions(ctx, derpMap)
+		if err != nil {
+			return err
+		}
+
+		diags := Diagnostics{}
+		for _, region := range childRegionsObj.Members {
+			err := mergeDERPRegion(ctx, regions, region)
+			diags = append(diags, AsDiagnostics(err)...)
+		}
+
+		upsertMember(parent, sectionKey, derpMap)
+		if len(diags) > 0 {
+			return diags
+		}
+		return nil
+	}
+}
+
+// derpRegions returns the Regions of the parent's derpMap, adding them if the
+// parent doesn't have any.
+func derpRegions(ctx *MergeContext, derpMap *jwcc.Object) (*jwcc.Object, error) {
+	existing := derpMap.Find("Regions")
+	if existing == nil {
+		regions := new(jwcc.Object)
+		derpMap.Members = append(derpMap.Members, &jwcc.Member{Key: ast.String("Regions").Quote(), Value: regions})
+		return regions, nil
+	}
+	regions, ok := existing.Value.(*jwcc.Object)
+	if !ok {
+		return nil, diagnosticAt(ctx.ParentPath, existing, RuleInvalidType, "[derpMap.Regions] must be an object, got %s", valueKind(existing.Value))
+	}
+	return regions, nil
+}
+
+func mergeDERPRegion(ctx *MergeContext, regions *jwcc.Object, region *jwcc.Member) error {
+	id := region.Key.String()
+	regionObj, ok := region.Value.(*jwcc.Object)
+	if !ok {
+		return ctx.Errorf(region, RuleInvalidType, "region [%s] in derpMap must be an object, got %s", id, valueKind(region.Value))
+	}
+	if regionID := regionObj.Find("RegionID"); regionID != nil && regionID.Value.JSON() != id {
+		return ctx.Errorf(regionID, RuleInvalidType, "region [%s] in derpMap has RegionID %s, expected the same ID as its key", id, regionID.Value.JSON())
+	}
+
+	existing := regions.FindKey(ast.TextEqual(id))
+	if existing == nil {
+		newMember := copyMember(region)
+		pathComment(newMember, ctx.ChildPath)
+		regions.Members = append(regions.Members, newMember)
+		return nil
+	}
+
+	existingSources := sourcesFromComments(existing.Comments().Before)
+	if len(existingSources) == 0 {
+		existingSources = []string{ctx.ParentPath}
+	}
+	if existingSources[0] != ctx.ParentPath {
+		return ctx.Errorf(region, RuleConflictingKey, "conflicting region ID [%s] in derpMap defined in [%s] and [%s]", id, existingSources[0], ctx.ChildPath)
+	}
+	existingObj, ok := existing.Value.(*jwcc.Object)
+	if !ok {
+		return diagnosticAt(ctx.ParentPath, existing, RuleInvalidType, "region [%s] in derpMap must be an object, got %s", id, valueKind(existing.Value))
+	}
+
+	diags := Diagnostics{}
+	for _, field := range regionObj.Members {
+		if strings.EqualFold(field.Key.String(), "Nodes") {
+			err := mergeDERPNodes(ctx, id, existingObj, field)
+			diags = append(diags, AsDiagnostics(err)...)
+			continue
+		}
+
+		existingField := existingObj.Find(field.Key.String())
+		if existingField == nil {
+			existingObj.Members = append(existingObj.Members, copyMember(field))
+			continue
+		}
+		if existingField.Value.JSON() != field.Value.JSON() {
+			diags = append(diags, AsDiagnostics(ctx.Errorf(field, RuleConflictingKey, "conflicting value for [%s] in derpMap region [%s], set to %s in [%s] and %s in [%s]", field.Key, id, existingField.Value.JSON(), ctx.ParentPath, field.Value.JSON(), ctx.ChildPath))...)
+		}
+	}
+
+	addMergeComment(existing, ctx.ParentPath, ctx.ChildPath)
+	addAuthorComments(existing, region)
+	if len(diags) > 0 {
+		return diags
+	}
+	return nil
+}
+
+// mergeDERPNodes adds the nodes of a child's region to the parent's region
+// with the same ID, by name.
+func mergeDERPNodes(ctx *MergeContext, id string, region *jwcc.Object, childNodes *jwcc.Member) error {
+	childArr, ok := childNodes.Value.(*jwcc.Array)
+	if !ok {
+		return ctx.Errorf(childNodes, RuleInvalidType, "Nodes of region [%s] in derpMap must be an array, got %s", id, valueKind(childNodes.Value))
+	}
+
+	var nodes *jwcc.Array
+	if existing := region.Find("Nodes"); existing != nil {
+		nodes, ok = existing.Value.(*jwcc.Array)
+		if !ok {
+			return diagnosticAt(ctx.ParentPath, existing, RuleInvalidType, "Nodes of region [%s] in derpMap must be an array, got %s", id, valueKind(existing.Value))
+		}
+	} else {
+		nodes = new(jwcc.Array)
+		region.Members = append(region.Members, &jwcc.Member{Key: ast.String("Nodes").Quote(), Value: nodes})
+	}
+
+	existingNodes := map[string]jwcc.Value{}
+	for _, node := range nodes.Values {
+		existingNodes[derpNodeName(node)] = node
+	}
+
+	diags := Diagnostics{}
+	for _, node := range childArr.Values {
+		name := derpNodeName(node)
+		if name == "" {
+			diags = append(diags, AsDiagnostics(ctx.Errorf(node, RuleInvalidType, "node in derpMap region [%s] must have a Name", id))...)
+			continue
+		}
+
+		existing, ok := existingNodes[name]
+		if !ok {
+			pathComment(node, ctx.ChildPath)
+			nodes.Values = append(nodes.Values, node)
+			existingNodes[name] = node
+			continue
+		}
+		if existing.JSON() != node.JSON() {
+			existingSources := sourcesFromComments(existing.Comments().Before)
+			if len(existingSources) == 0 {
+				existingSources = []string{ctx.ParentPath}
+			}
+			diags = append(diags, AsDiagnostics(ctx.Errorf(node, RuleConflictingKey, "conflicting node [%s] in derpMap region [%s] defined in [%s] and [%s]", name, id, existingSources[0], ctx.ChildPath))...)
+		}
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+	return nil
+}
+
+// derpNodeName returns the Name of a DERP node, or "" if it doesn't have one.
+func derpNodeName(node jwcc.Value) string {
+	obj, ok := node.(*jwcc.Object)
+	if !ok {
+		return ""
+	}
+	name := obj.Find("Name")
+	if name == nil {
+		return ""
+	}
+	d, ok := name.Value.(*jwcc.Datum)
+	if !ok {
+		return ""
+	}
+	text, ok := d.Value.(ast.Text)
+	if !ok {
+		return ""
+	}
+	return text.String()
+}
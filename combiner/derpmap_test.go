@@ -0,0 +1,117 @@
+package combiner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const DERP_PARENT = `{
+	"derpMap": {
+		"OmitDefaultRegions": true,
+		"Regions": {
+			"900": {
+				"RegionID":   900,
+				"RegionCode": "infra",
+				"Nodes": [{"Name": "900a", "RegionID": 900, "HostName": "derp1.example.com"}],
+			},
+		},
+	},
+}`
+
+func mergeDERPChildren(t *testing.T, children ...string) (*ParsedDocument, error) {
+	t.Helper()
+	parent, err := jwcc.Parse(strings.NewReader(DERP_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"}
+
+	childDocs := []*ParsedDocument{}
+	for i, child := range children {
+		doc, err := jwcc.Parse(strings.NewReader(child))
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		childDocs = append(childDocs, &ParsedDocument{Object: doc.Value.(*jwcc.Object), Path: fmt.Sprintf("child%d", i+1)})
+	}
+
+	sections, err := DefaultRegistry().Allow([]string{"derpMap"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	return parentDoc, Merge(parentDoc, childDocs, Options{Sections: sections})
+}
+
+func TestHandleDERPMap(t *testing.T) {
+	parentDoc, err := mergeDERPChildren(t, `{
+		"derpMap": {
+			"OmitDefaultRegions": false,
+			"Regions": {
+				"900": {"Nodes": [
+					{"Name": "900a", "RegionID": 900, "HostName": "derp1.example.com"},
+					{"Name": "900b", "RegionID": 900, "HostName": "derp2.example.com"},
+				]},
+				"901": {"RegionID": 901, "RegionCode": "finance", "Nodes": [{"Name": "901a", "RegionID": 901, "HostName": "derp.finance.example.com"}]},
+			},
+		},
+	}`)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	policy, diags := DecodePolicy(parentDoc.Object)
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got [%v]", diags)
+	}
+	derpMap := policy.DERPMap
+	if !derpMap.OmitDefaultRegions {
+		t.Fatalf("expected OmitDefaultRegions from the parent, got [%v]", derpMap.OmitDefaultRegions)
+	}
+	if len(derpMap.Regions) != 2 || derpMap.Regions["901"].RegionCode != "finance" {
+		t.Fatalf("expected region from child, got [%+v]", derpMap.Regions)
+	}
+	if nodes := derpMap.Regions["900"].Nodes; len(nodes) != 2 || nodes[1].Name != "900b" || derpMap.Regions["900"].RegionCode != "infra" {
+		t.Fatalf("expected nodes merged by name, got [%+v]", derpMap.Regions["900"])
+	}
+
+	regions := parentDoc.Object.Find("derpMap").Value.(*jwcc.Object).Find("Regions").Value.(*jwcc.Object)
+	if sources := sourcesFromComments(regions.Find("900").Comments().Before); len(sources) != 2 || sources[0] != "parent" || sources[1] != "child1" {
+		t.Fatalf("expected region from parent and child, got [%v]", sources)
+	}
+	if sources := sourcesFromComments(regions.Find("901").Comments().Before); len(sources) != 1 || sources[0] != "child1" {
+		t.Fatalf("expected region from child, got [%v]", sources)
+	}
+}
+
+func TestHandleDERPMapConflicts(t *testing.T) {
+	_, err := mergeDERPChildren(t, `{
+		"derpMap": {"Regions": {
+			"900": {"RegionCode": "finance", "Nodes": [{"Name": "900a", "RegionID": 900, "HostName": "derp.finance.example.com"}]},
+			"901": {"RegionID": 901, "Nodes": [{"Name": "901a", "RegionID": 901, "HostName": "derp1.example.com"}]},
+		}},
+	}`, `{
+		"derpMap": {"Regions": {
+			"901": {"RegionID": 901, "Nodes": [{"Name": "901b", "RegionID": 901, "HostName": "derp2.example.com"}]},
+			"902": {"RegionID": 903, "Nodes": [{"HostName": "derp3.example.com"}]},
+		}},
+	}`)
+
+	expected := []string{
+		"child1:3:12: conflicting value for [RegionCode] in derpMap region [900], set to \"infra\" in [parent] and \"finance\" in [child1]",
+		"child1:3:47: conflicting node [900a] in derpMap region [900] defined in [parent] and [child1]",
+		"child2:3:4: conflicting region ID [901] in derpMap defined in [child1] and [child2]",
+		"child2:4:12: region [902] in derpMap has RegionID 903, expected the same ID as its key",
+	}
+	diags := AsDiagnostics(err)
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), err)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] {
+			t.Errorf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package combiner
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// A Severity is how serious a Diagnostic is.
+type Severity string
+
+const (
+	// SeverityError fails the run.
+	SeverityError Severity = "error"
+	// SeverityWarning is reported without failing the run.
+	SeverityWarning Severity = "warning"
+)
+
+// Rule IDs identify the check that produced a Diagnostic.
+const (
+	RuleReadFile           = "read-file"
+	RuleParse              = "parse"
+	RuleInvalidType        = "invalid-type"
+	RuleUnknownField       = "unknown-field"
+	RuleMissingField       = "missing-field"
+	RuleUnsupportedSection = "unsupported-section"
+	RuleConflictingKey     = "conflicting-key"
+	RuleDuplicateKey       = "duplicate-key"
+	RuleSectionHandler     = "section-handler"
+	RuleTagNamespace       = "tag-namespace"
+	RuleGroupOwnership     = "group-ownership"
+	RuleAllocation         = "allocation"
+	RuleRouteOverlap       = "route-overlap"
+	RuleUnmatchedPath      = "unmatched-path"
+	RuleUndefinedReference = "undefined-reference"
+	RuleUnusedDefinition   = "unused-definition"
+	RuleACLTest            = "acl-test"
+	RuleSSHTest            = "ssh-test"
+)
+
+// A Diagnostic is a problem found in a policy file.
+type Diagnostic struct {
+	Path     string   `json:"file"`
+	Line     int      `json:"line"`   // 1-based, 0 if unknown
+	Column   int      `json:"column"` // 1-based, 0 if unknown
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) Error() string {
+	if d.Path == "" {
+		return d.Message
+	}
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.Path, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.Path, d.Line, d.Column, d.Message)
+}
+
+// diagnosticAt returns an error Diagnostic from rule for the position of v in
+// the file at path.
+func diagnosticAt(path string, v jwcc.Value, rule string, format string, args ...any) Diagnostic {
+	d := Diagnostic{Path: path, Severity: SeverityError, Rule: rule, Message: fmt.Sprintf(format, args...)}
+	if v != nil {
+		loc := sourceLocation(v)
+		if loc.First.Line > 0 {
+			d.Line = loc.First.Line
+			d.Column = loc.First.Column + 1
+		}
+	}
+	return d
+}
+
+// Diagnostics is a list of problems, returned as an error by GatherChildren
+// and Merge so every problem can be reported at once.
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	lines := make([]string, 0, len(d))
+	for _, diag := range d {
+		lines = append(lines, diag.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasErrors reports whether any diagnostic in d is an error.
+func (d Diagnostics) HasErrors() bool {
+	for _, diag := range d {
+		if diag.Severity != SeverityWarning {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort orders d by path and position, dropping exact duplicates.
+func (d *Diagnostics) Sort() {
+	sort.SliceStable(*d, func(i, j int) bool {
+		a, b := (*d)[i], (*d)[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	deduped := (*d)[:0]
+	for i, diag := range *d {
+		if i > 0 && diag == deduped[len(deduped)-1] {
+			continue
+		}
+		deduped = append(deduped, diag)
+	}
+	*d = deduped
+}
+
+// AsDiagnostics returns the diagnostics in err. Errors that aren't a Diagnostic
+// or Diagnostics are returned as a single error Diagnostic without a path or rule.
+func AsDiagnostics(err error) Diagnostics {
+	if err == nil {
+		return nil
+	}
+
+	var diags Diagnostics
+	if errors.As(err, &diags) {
+		return diags
+	}
+	var diag Diagnostic
+	if errors.As(err, &diag) {
+		return Diagnostics{diag}
+	}
+	return Diagnostics{{Severity: SeverityError, Message: err.Error()}}
+}
+
+// valueKind describes the JSON type of v for diagnostics.
+func valueKind(v jwcc.Value) string {
+	switch v := v.(type) {
+	case *jwcc.Object:
+		return "object"
+	case *jwcc.Array:
+		return "array"
+	case *jwcc.Datum:
+		switch v.Value.(type) {
+		case ast.Text:
+			return "string"
+		case ast.Number:
+			return "number"
+		case ast.Bool:
+			return "boolean"
+		}
+	}
+	return "null"
+}
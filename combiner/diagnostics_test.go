@@ -0,0 +1,93 @@
+package combiner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiagnosticError(t *testing.T) {
+	d := Diagnostic{Path: "child.hujson", Line: 3, Column: 5, Message: "oops"}
+	if d.Error() != "child.hujson:3:5: oops" {
+		t.Fatalf("unexpected error [%s]", d)
+	}
+
+	d = Diagnostic{Path: "child.hujson", Message: "oops"}
+	if d.Error() != "child.hujson: oops" {
+		t.Fatalf("unexpected error [%s]", d)
+	}
+}
+
+func TestDiagnosticsSort(t *testing.T) {
+	diags := Diagnostics{
+		{Path: "b", Line: 1, Column: 1, Message: "b1"},
+		{Path: "a", Line: 2, Column: 1, Message: "a2"},
+		{Path: "a", Line: 1, Column: 3, Message: "a1"},
+		{Path: "b", Line: 1, Column: 1, Message: "b1"},
+	}
+	diags.Sort()
+
+	if len(diags) != 3 {
+		t.Fatalf("duplicates should be removed, got [%v]", diags)
+	}
+	if diags[0].Message != "a1" || diags[1].Message != "a2" || diags[2].Message != "b1" {
+		t.Fatalf("unexpected order [%v]", diags)
+	}
+}
+
+func TestAsDiagnostics(t *testing.T) {
+	if diags := AsDiagnostics(nil); diags != nil {
+		t.Fatalf("expected no diagnostics, got [%v]", diags)
+	}
+
+	diags := AsDiagnostics(Diagnostic{Path: "a", Message: "oops"})
+	if len(diags) != 1 || diags[0].Path != "a" {
+		t.Fatalf("unexpected diagnostics [%v]", diags)
+	}
+
+	diags = AsDiagnostics(errors.New("oops"))
+	if len(diags) != 1 || diags[0].Message != "oops" {
+		t.Fatalf("unexpected diagnostics [%v]", diags)
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.hujson")
+	err := os.WriteFile(path, []byte("{\n  \"acls\": [,]\n}\n"), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	_, err = Parse(path)
+	var d Diagnostic
+	if !errors.As(err, &d) {
+		t.Fatalf("expected a diagnostic, got [%v]", err)
+	}
+	if d.Line != 2 || d.Column != 12 {
+		t.Fatalf("position should be [2:12], got [%d:%d]", d.Line, d.Column)
+	}
+}
+
+func TestGatherChildrenReportsAllErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"good.hujson":   `{"acls": []}`,
+		"array.json":    `[]`,
+		"broken.hujson": `{"acls": [}`,
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+	}
+
+	children, err := GatherChildren(dir, Options{})
+	if len(children) != 1 {
+		t.Fatalf("children length should be [1], got [%v]", len(children))
+	}
+	if diags := AsDiagnostics(err); len(diags) != 2 {
+		t.Fatalf("expected [2] diagnostics, got [%v]", err)
+	}
+}
@@ -0,0 +1,241 @@
+package combiner
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// A ChangeKind is how a value differs between two policies.
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	ChangeChanged ChangeKind = "changed"
+)
+
+// A Change is a semantic difference between two combined policies, such as a
+// rule added to acls or a member removed from a group.
+type Change struct {
+	Kind    ChangeKind
+	Section string
+	// Where is the array or object the value was added to or removed from,
+	// e.g. groups["group:finance"], or the value itself if it changed.
+	Where string
+	Value string // JSON
+	Old   string // JSON, only set for ChangeChanged
+	// Sources are the files the value came from in the new policy, or in the
+	// old policy if it was removed.
+	Sources []string
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s: %s (from %s)", c.Where, c.Value, DescribeSources(c.Sources))
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s: %s (from %s)", c.Where, c.Value, DescribeSources(c.Sources))
+	}
+	return fmt.Sprintf("~ %s: %s -> %s (from %s)", c.Where, c.Old, c.Value, DescribeSources(c.Sources))
+}
+
+// DiffPolicies returns the semantic differences between two combined
+// policies, ignoring formatting, comments and the order of keys. Entries of
+// arrays, such as acls rules or the members of a group, are compared as a
+// whole, so a changed rule is reported as removed and added. Sections are
+// returned in alphabetical order.
+func DiffPolicies(oldDoc *jwcc.Object, newDoc *jwcc.Object) []Change {
+	// section names are compared in their canonical spelling, as Merge writes them
+	names := DefaultRegistry().Names()
+	findSection := func(doc *jwcc.Object, section string) *jwcc.Member {
+		for _, m := range doc.Members {
+			if canonicalSectionName(m.Key.String(), names) == section {
+				return m
+			}
+		}
+		return nil
+	}
+
+	sections := []string{}
+	for _, doc := range []*jwcc.Object{oldDoc, newDoc} {
+		for _, m := range doc.Members {
+			if section := canonicalSectionName(m.Key.String(), names); !slices.Contains(sections, section) {
+				sections = append(sections, section)
+			}
+		}
+	}
+	sort.Strings(sections)
+
+	changes := []Change{}
+	for _, section := range sections {
+		var oldValue, newValue jwcc.Value
+		var oldSources, newSources []string
+		if m := findSection(oldDoc, section); m != nil {
+			oldValue, oldSources = m.Value, sourcesFromComments(m.Comments().Before)
+		}
+		if m := findSection(newDoc, section); m != nil {
+			newValue, newSources = m.Value, sourcesFromComments(m.Comments().Before)
+		}
+		d := policyDiff{section: section}
+		d.diffValue(section, oldValue, newValue, oldSources, newSources)
+		changes = append(changes, d.changes...)
+	}
+	return changes
+}
+
+type policyDiff struct {
+	section string
+	changes []Change
+}
+
+func (d *policyDiff) add(kind ChangeKind, where string, v jwcc.Value, sources []string) {
+	d.changes = append(d.changes, Change{Kind: kind, Section: d.section, Where: where, Value: v.JSON(), Sources: sources})
+}
+
+// diffValue compares the values at where in the two policies, either of which
+// may be nil if it's only in one of them.
+func (d *policyDiff) diffValue(where string, oldValue jwcc.Value, newValue jwcc.Value, oldSources []string, newSources []string) {
+	switch {
+	case oldValue == nil && newValue == nil:
+		return
+	case oldValue == nil:
+		d.addAll(ChangeAdded, where, newValue, newSources)
+		return
+	case newValue == nil:
+		d.addAll(ChangeRemoved, where, oldValue, oldSources)
+		return
+	}
+
+	oldArr, oldIsArr := oldValue.(*jwcc.Array)
+	newArr, newIsArr := newValue.(*jwcc.Array)
+	if oldIsArr && newIsArr {
+		d.diffArray(where, oldArr, newArr, oldSources, newSources)
+		return
+	}
+	oldObj, oldIsObj := oldValue.(*jwcc.Object)
+	newObj, newIsObj := newValue.(*jwcc.Object)
+	if oldIsObj && newIsObj {
+		d.diffObject(where, oldObj, newObj, oldSources, newSources)
+		return
+	}
+
+	if canonicalJSON(oldValue) != canonicalJSON(newValue) {
+		d.changes = append(d.changes, Change{Kind: ChangeChanged, Section: d.section, Where: where, Value: newValue.JSON(), Old: oldValue.JSON(), Sources: newSources})
+	}
+}
+
+// addAll reports each entry of an array only in one of the policies, or each
+// member of an object, down to the first value that isn't an object.
+func (d *policyDiff) addAll(kind ChangeKind, where string, v jwcc.Value, sources []string) {
+	switch v := v.(type) {
+	case *jwcc.Array:
+		for _, item := range v.Values {
+			sources = inheritSources(item, sources)
+			d.add(kind, where, item, sources)
+		}
+	case *jwcc.Object:
+		for _, m := range v.Members {
+			sources = inheritSources(m, sources)
+			d.addMember(kind, memberPath(where, m.Key.String()), m.Value, sources)
+		}
+	default:
+		d.add(kind, where, v, sources)
+	}
+}
+
+// addMember reports a member only in one of the policies, or each of its
+// members if it's an object, such as the routes in autoApprovers.
+func (d *policyDiff) addMember(kind ChangeKind, where string, v jwcc.Value, sources []string) {
+	if _, ok := v.(*jwcc.Object); ok {
+		d.addAll(kind, where, v, sources)
+		return
+	}
+	d.add(kind, where, v, sources)
+}
+
+// diffArray reports the entries only in one of the arrays. Entries are
+// matched by value, so reordering an array isn't a change.
+func (d *policyDiff) diffArray(where string, oldArr *jwcc.Array, newArr *jwcc.Array, oldSources []string, newSources []string) {
+	remaining := map[string]int{}
+	for _, item := range newArr.Values {
+		remaining[canonicalJSON(item)]++
+	}
+	for _, item := range oldArr.Values {
+		oldSources = inheritSources(item, oldSources)
+		key := canonicalJSON(item)
+		if remaining[key] > 0 {
+			remaining[key]--
+			continue
+		}
+		d.add(ChangeRemoved, where, item, oldSources)
+	}
+
+	remaining = map[string]int{}
+	for _, item := range oldArr.Values {
+		remaining[canonicalJSON(item)]++
+	}
+	for _, item := range newArr.Values {
+		newSources = inheritSources(item, newSources)
+		key := canonicalJSON(item)
+		if remaining[key] > 0 {
+			remaining[key]--
+			continue
+		}
+		d.add(ChangeAdded, where, item, newSources)
+	}
+}
+
+// diffObject compares the members of the objects by key.
+func (d *policyDiff) diffObject(where string, oldObj *jwcc.Object, newObj *jwcc.Object, oldSources []string, newSources []string) {
+	oldMembers := map[string]jwcc.Value{}
+	oldMemberSources := map[string][]string{}
+	for _, m := range oldObj.Members {
+		oldSources = inheritSources(m, oldSources)
+		if _, ok := oldMembers[m.Key.String()]; !ok {
+			oldMembers[m.Key.String()], oldMemberSources[m.Key.String()] = m.Value, oldSources
+		}
+	}
+
+	for _, m := range oldObj.Members {
+		if newObj.FindKey(ast.TextEqual(m.Key.String())) == nil {
+			d.addMember(ChangeRemoved, memberPath(where, m.Key.String()), m.Value, oldMemberSources[m.Key.String()])
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, m := range newObj.Members {
+		newSources = inheritSources(m, newSources)
+		key := m.Key.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		oldValue, ok := oldMembers[key]
+		if !ok {
+			d.addMember(ChangeAdded, memberPath(where, key), m.Value, newSources)
+			continue
+		}
+		d.diffValue(memberPath(where, key), oldValue, m.Value, oldMemberSources[key], newSources)
+	}
+}
+
+// canonicalJSON returns the JSON of v with the keys of objects sorted, so
+// values that only differ in the order of their keys are equal.
+func canonicalJSON(v jwcc.Value) string {
+	var decoded any
+	err := json.Unmarshal([]byte(v.JSON()), &decoded)
+	if err != nil {
+		return v.JSON()
+	}
+	b, err := json.Marshal(decoded)
+	if err != nil {
+		return v.JSON()
+	}
+	return string(b)
+}
@@ -0,0 +1,120 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const DIFF_OLD = `{
+	"acls": [
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		{"action": "accept", "src": ["group:eng"], "dst": ["tag:server:22"]},
+		// acl-combiner: from ` + "`finance.hujson`" + `
+		{"action": "accept", "src": ["group:finance"], "dst": ["tag:finance:443"]},
+	],
+	"groups": {
+		// acl-combiner: from ` + "`finance.hujson`" + `
+		"group:finance": ["alice@example.com", "bob@example.com"],
+		"group:old":     ["carol@example.com"],
+	},
+	"tagOwners": {
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		"tag:server": ["group:eng"],
+	},
+	"randomizeClientPort": true,
+}`
+
+func parseDiffDoc(t *testing.T, s string) *jwcc.Object {
+	t.Helper()
+	doc, err := jwcc.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	return doc.Value.(*jwcc.Object)
+}
+
+func TestDiffPolicies(t *testing.T) {
+	newDoc := parseDiffDoc(t, `{
+		"acls": [
+			// acl-combiner: from `+"`finance.hujson`"+`
+			{"action": "accept", "src": ["group:finance"], "dst": ["tag:finance:443"]},
+			// acl-combiner: from `+"`eng.hujson`"+`
+			{"action": "accept", "src": ["group:eng"], "dst": ["tag:server:443"]},
+		],
+		"autoApprovers": {
+			"routes": {
+				// acl-combiner: from `+"`finance.hujson`"+`
+				"10.0.10.0/24": ["tag:finance"],
+			},
+		},
+		"groups": {
+			// acl-combiner: from `+"`finance.hujson`"+`
+			"group:finance": ["bob@example.com", "dave@example.com"],
+		},
+		"tagOwners": {
+			// acl-combiner: from `+"`parent.hujson`"+`
+			"tag:server": ["group:eng", "group:ops"],
+		},
+		"randomizeClientPort": false,
+	}`)
+
+	expected := []string{
+		`- acls: {"action":"accept","src":["group:eng"],"dst":["tag:server:22"]} (from parent.hujson)`,
+		`+ acls: {"action":"accept","src":["group:eng"],"dst":["tag:server:443"]} (from eng.hujson)`,
+		`+ autoApprovers["routes"]["10.0.10.0/24"]: ["tag:finance"] (from finance.hujson)`,
+		`- groups["group:old"]: ["carol@example.com"] (from finance.hujson)`,
+		`- groups["group:finance"]: "alice@example.com" (from finance.hujson)`,
+		`+ groups["group:finance"]: "dave@example.com" (from finance.hujson)`,
+		`~ randomizeClientPort: true -> false (from unknown)`,
+		`+ tagOwners["tag:server"]: "group:ops" (from parent.hujson)`,
+	}
+	changes := DiffPolicies(parseDiffDoc(t, DIFF_OLD), newDoc)
+	if len(changes) != len(expected) {
+		t.Fatalf("expected [%d] changes, got [%v]", len(expected), changes)
+	}
+	for i, c := range changes {
+		if c.String() != expected[i] {
+			t.Errorf("change [%d] should be [%s], got [%s]", i, expected[i], c)
+		}
+	}
+}
+
+func TestDiffPoliciesIgnoresFormatting(t *testing.T) {
+	newDoc := parseDiffDoc(t, `{
+		"randomizeClientPort": true,
+		"tagOwners": {"tag:server": ["group:eng"]},
+		"groups": {
+			"group:old":     ["carol@example.com"],
+			// moved
+			"group:finance": ["bob@example.com", "alice@example.com"],
+		},
+		"acls": [
+			{"src": ["group:finance"], "dst": ["tag:finance:443"], "action": "accept"},
+			{"action": "accept", "src": ["group:eng"], "dst": ["tag:server:22"]},
+		],
+	}`)
+
+	changes := DiffPolicies(parseDiffDoc(t, DIFF_OLD), newDoc)
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got [%v]", changes)
+	}
+}
+
+func TestDiffPoliciesMatchesSectionCase(t *testing.T) {
+	// a hand-written policy against the combined one, which spells sections canonically
+	oldDoc := parseDiffDoc(t, `{
+		"ACLs": [{"action": "accept", "src": ["group:eng"], "dst": ["tag:server:22"]}],
+		"Groups": {"group:eng": ["alice@example.com"]},
+	}`)
+	newDoc := parseDiffDoc(t, `{
+		"acls": [{"action": "accept", "src": ["group:eng"], "dst": ["tag:server:22"]}],
+		"groups": {"group:eng": ["alice@example.com", "bob@example.com"]},
+	}`)
+
+	changes := DiffPolicies(oldDoc, newDoc)
+	if len(changes) != 1 || changes[0].Section != "groups" {
+		t.Fatalf("expected one change in [groups], got [%v]", changes)
+	}
+}
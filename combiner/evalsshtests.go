@@ -0,0 +1,108 @@
+package combiner
+
+import (
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// sshActions are the results of evaluating an SSH connection, named as in sshTests.
+const (
+	sshAccept = "accept"
+	sshCheck  = "check"
+	sshDeny   = "deny"
+)
+
+// EvaluateSSHTests evaluates every entry of the sshTests section of doc against
+// its ssh rules, returning a Diagnostic for each failed assertion.
+func EvaluateSSHTests(doc *jwcc.Object) Diagnostics {
+	e, diags := newPolicyEvaluator(doc)
+
+	tests, d := decodeEntries[SSHTest](doc, "sshTests")
+	diags = append(diags, d...)
+
+	for _, test := range tests {
+		diags = append(diags, e.evaluateSSHTest(test.policyEntry, test.Value)...)
+	}
+
+	diags.Sort()
+	return diags
+}
+
+func (e *policyEvaluator) evaluateSSHTest(entry policyEntry, t SSHTest) Diagnostics {
+	diags := Diagnostics{}
+
+	for _, id := range append(append([]string{}, t.Src...), t.Dst...) {
+		if isTag(id) && e.policy.TagOwners[id] == nil {
+			diags = append(diags, entry.errorf(RuleSSHTest, "ssh test failed: tag [%s] is not defined in tagOwners", id))
+		}
+	}
+
+	expectations := []struct {
+		action string
+		users  []string
+	}{
+		{sshAccept, t.Accept},
+		{sshCheck, t.Check},
+		{sshDeny, t.Deny},
+	}
+
+	for _, src := range t.Src {
+		for _, dst := range t.Dst {
+			for _, expected := range expectations {
+				for _, user := range expected.users {
+					action, rule := e.sshAction(src, dst, user)
+					if action == expected.action {
+						continue
+					}
+
+					switch action {
+					case sshDeny:
+						diags = append(diags, entry.errorf(RuleSSHTest, "ssh test failed: [%s] cannot ssh to [%s] as [%s], expected %s", src, dst, user, expected.action))
+					default:
+						diags = append(diags, entry.errorf(RuleSSHTest, "ssh test failed: [%s] can ssh to [%s] as [%s] with %s, allowed by %s, expected %s", src, dst, user, action, describeEntry("ssh", rule), expected.action))
+					}
+				}
+			}
+		}
+	}
+	return diags
+}
+
+// sshAction returns the action of the first ssh rule allowing src to connect
+// to dst as user, and the rule, or sshDeny if no rule allows it.
+func (e *policyEvaluator) sshAction(src string, dst string, user string) (string, policyEntry) {
+	for _, r := range e.ssh {
+		if r.Value.Action != sshAccept && r.Value.Action != sshCheck {
+			continue
+		}
+		if !e.anyMatches(r.Value.Src, src, "") || !e.anyMatches(r.Value.Dst, dst, selfUser(src)) {
+			continue
+		}
+		for _, ruleUser := range r.Value.Users {
+			if sshUserMatches(ruleUser, user, src) {
+				return r.Value.Action, r.policyEntry
+			}
+		}
+	}
+	return sshDeny, policyEntry{}
+}
+
+// sshUserMatches reports whether ruleUser, an entry of an ssh rule's users,
+// allows logging in as user when connecting from src -
+// https://tailscale.com/kb/1337/acl-syntax#users
+func sshUserMatches(ruleUser string, user string, src string) bool {
+	switch {
+	case ruleUser == "*" || ruleUser == user:
+		return true
+	case ruleUser == "autogroup:nonroot":
+		return user != "root" && !strings.HasPrefix(user, "localpart:")
+	}
+
+	domain, ok := strings.CutPrefix(ruleUser, "localpart:*@")
+	if !ok || !isUser(src) {
+		return false
+	}
+	localpart, srcDomain, _ := strings.Cut(src, "@")
+	return srcDomain == domain && user == localpart
+}
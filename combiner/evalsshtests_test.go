@@ -0,0 +1,95 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const SSH_EVAL_POLICY = `{
+	"groups": {
+		"group:sre": ["bob@example.com"],
+	},
+	"tagOwners": {
+		"tag:prod": ["group:sre"],
+	},
+	"ssh": [
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		{"action": "accept", "src": ["autogroup:member"], "dst": ["autogroup:self"], "users": ["root", "autogroup:nonroot"]},
+		{"action": "check", "src": ["group:sre"], "dst": ["tag:prod"], "users": ["root"]},
+		// acl-combiner: from ` + "`child.hujson`" + `
+		{"action": "accept", "src": ["group:sre", "tag:prod"], "dst": ["tag:prod"], "users": ["autogroup:nonroot", "localpart:*@example.com"]},
+	],
+	"sshTests": [
+		// acl-combiner: from ` + "`tests.hujson`" + `
+		TESTS
+	],
+}`
+
+func evalSSHTestsWith(t *testing.T, tests string) Diagnostics {
+	t.Helper()
+	doc, err := jwcc.Parse(strings.NewReader(strings.Replace(SSH_EVAL_POLICY, "TESTS", tests, 1)))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	return EvaluateSSHTests(doc.Value.(*jwcc.Object))
+}
+
+func TestEvaluateSSHTestsPass(t *testing.T) {
+	diags := evalSSHTestsWith(t, `
+		{"src": ["autogroup:member"], "dst": ["autogroup:self"], "accept": ["root", "autogroup:nonroot"]},
+		{"src": "alice@example.com", "dst": ["alice@example.com"], "accept": ["root", "alice"], "deny": []},
+		{"src": "alice@example.com", "dst": ["bob@example.com", "tag:prod"], "deny": ["root", "alice"]},
+		{"src": "bob@example.com", "dst": ["tag:prod"], "check": ["root"], "accept": ["ubuntu", "bob"]},
+		{"src": "tag:prod", "dst": ["tag:prod"], "accept": ["ubuntu", "prod"], "deny": ["root"]},
+	`)
+	if len(diags) != 0 {
+		t.Fatalf("expected no failures, got [%v]", diags)
+	}
+}
+
+func TestEvaluateSSHTestsFailures(t *testing.T) {
+	diags := evalSSHTestsWith(t, `
+		{"src": "bob@example.com", "dst": ["tag:prod"], "accept": ["root"]},
+		{"src": "alice@example.com", "dst": ["tag:prod"], "check": ["root"]},
+		{"src": "bob@example.com", "dst": ["tag:prod"], "deny": ["ubuntu"]},
+		{"src": "bob@example.com", "dst": ["tag:dev"], "deny": ["root"]},
+	`)
+
+	expected := []string{
+		"tests.hujson:18:3: ssh test failed: [bob@example.com] can ssh to [tag:prod] as [root] with check, allowed by ssh[1] from [parent.hujson], expected accept",
+		"tests.hujson:19:3: ssh test failed: [alice@example.com] cannot ssh to [tag:prod] as [root], expected check",
+		"tests.hujson:20:3: ssh test failed: [bob@example.com] can ssh to [tag:prod] as [ubuntu] with accept, allowed by ssh[2] from [child.hujson], expected deny",
+		"tests.hujson:21:3: ssh test failed: tag [tag:dev] is not defined in tagOwners",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] failures, got [%v]", len(expected), diags)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] || d.Rule != RuleSSHTest {
+			t.Fatalf("failure [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+}
+
+func TestSSHUserMatches(t *testing.T) {
+	tests := []struct {
+		ruleUser string
+		user     string
+		expected bool
+	}{
+		{"root", "root", true},
+		{"*", "ubuntu", true},
+		{"autogroup:nonroot", "ubuntu", true},
+		{"autogroup:nonroot", "root", false},
+		{"localpart:*@example.com", "alice", true},
+		{"localpart:*@example.com", "bob", false},
+		{"localpart:*@example.org", "alice", false},
+	}
+	for _, tt := range tests {
+		if got := sshUserMatches(tt.ruleUser, tt.user, "alice@example.com"); got != tt.expected {
+			t.Fatalf("user [%s] for [%s] should be [%v], got [%v]", tt.user, tt.ruleUser, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,549 @@
+package combiner
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// policyEvaluator answers access questions about a merged policy without
+// talking to the Tailscale API. Users and tags don't have addresses offline,
+// so they only match rules naming them directly or through groups and autogroups.
+type policyEvaluator struct {
+	policy Policy
+	acls   []decodedEntry[ACLRule]
+	grants []decodedEntry[Grant]
+	ssh    []decodedEntry[SSHRule]
+}
+
+type decodedEntry[T any] struct {
+	policyEntry
+	Value T
+}
+
+// newPolicyEvaluator decodes the sections of doc used to evaluate tests and sshTests,
+// returning diagnostics for sections and entries that can't be decoded.
+func newPolicyEvaluator(doc *jwcc.Object) (*policyEvaluator, Diagnostics) {
+	e := &policyEvaluator{}
+	policy, diags := DecodePolicy(doc)
+	e.policy = *policy
+
+	// DecodePolicy already reported the entries that can't be decoded
+	e.acls, _ = decodeEntries[ACLRule](doc, "acls")
+	e.grants, _ = decodeEntries[Grant](doc, "grants")
+	e.ssh, _ = decodeEntries[SSHRule](doc, "ssh")
+
+	return e, diags
+}
+
+// decodeEntries decodes each item of the array section named key in doc.
+func decodeEntries[T any](doc *jwcc.Object, key string) ([]decodedEntry[T], Diagnostics) {
+	decoded := []decodedEntry[T]{}
+	diags := Diagnostics{}
+	for _, entry := range sectionEntries(doc, key) {
+		var v T
+		if err := decodeValue(entry.Value, &v); err != nil {
+			diags = append(diags, decodeDiagnostic(sourcePath(entry.Sources), entry.Value, fmt.Sprintf("%s[%d]", key, entry.Index), err))
+			continue
+		}
+		decoded = append(decoded, decodedEntry[T]{policyEntry: entry, Value: v})
+	}
+	return decoded, diags
+}
+
+// EvaluateTests evaluates every entry of the tests section of doc against its
+// acls and grants, returning a Diagnostic for each failed assertion.
+func EvaluateTests(doc *jwcc.Object) Diagnostics {
+	e, diags := newPolicyEvaluator(doc)
+
+	tests, d := decodeEntries[ACLTest](doc, "tests")
+	diags = append(diags, d...)
+
+	for _, test := range tests {
+		diags = append(diags, e.evaluateTest(test.policyEntry, test.Value)...)
+	}
+
+	diags.Sort()
+	return diags
+}
+
+func (e *policyEvaluator) evaluateTest(entry policyEntry, t ACLTest) Diagnostics {
+	diags := Diagnostics{}
+	if t.Proto == "" {
+		t.Proto = "tcp"
+	}
+
+	if isTag(t.Src) && e.policy.TagOwners[t.Src] == nil {
+		diags = append(diags, entry.errorf(RuleACLTest, "test failed: tag [%s] in src is not defined in tagOwners", t.Src))
+	}
+
+	check := func(dst string, expectAccept bool) {
+		host, port, err := parseTestDestination(dst)
+		if err != nil {
+			diags = append(diags, entry.errorf(RuleACLTest, "test failed: %v", err))
+			return
+		}
+		if isTag(host) && e.policy.TagOwners[host] == nil {
+			diags = append(diags, entry.errorf(RuleACLTest, "test failed: tag [%s] in [%s] is not defined in tagOwners", host, dst))
+		}
+
+		allowedBy, allowed := e.allowedBy(t, host, port)
+		switch {
+		case expectAccept && !allowed:
+			diags = append(diags, entry.errorf(RuleACLTest, "test failed: [%s] cannot access [%s] over [%s], expected accept", t.Src, dst, t.Proto))
+		case !expectAccept && allowed:
+			diags = append(diags, entry.errorf(RuleACLTest, "test failed: [%s] can access [%s] over [%s], allowed by %s, expected deny", t.Src, dst, t.Proto, allowedBy))
+		}
+	}
+
+	for _, dst := range t.Accept {
+		check(dst, true)
+	}
+	for _, dst := range t.Deny {
+		check(dst, false)
+	}
+	return diags
+}
+
+// allowedBy reports whether t.Src can reach host on port, and describes the
+// first rule or grant allowing it.
+func (e *policyEvaluator) allowedBy(t ACLTest, host string, port int) (string, bool) {
+	for _, r := range e.acls {
+		if e.aclAllows(r.Value, t, host, port) {
+			return describeEntry("acls", r.policyEntry), true
+		}
+	}
+	for _, g := range e.grants {
+		if e.grantAllows(g.Value, t, host, port) {
+			return describeEntry("grants", g.policyEntry), true
+		}
+	}
+	return "", false
+}
+
+func describeEntry(section string, entry policyEntry) string {
+	return fmt.Sprintf("%s[%d] from [%s]", section, entry.Index, DescribeSources(entry.Sources))
+}
+
+func (e *policyEvaluator) aclAllows(r ACLRule, t ACLTest, host string, port int) bool {
+	if r.Action != "accept" || !protoMatches(r.Proto, t.Proto) {
+		return false
+	}
+	if !e.anyMatches(r.Src, t.Src, "") || !e.postureMatches(r.SrcPosture, t.SrcPostureAttrs) {
+		return false
+	}
+	for _, dst := range r.Dst {
+		dstHost, ports, ok := splitHostPorts(dst)
+		if ok && e.matches(dstHost, host, selfUser(t.Src), nil) && portsMatch(ports, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *policyEvaluator) grantAllows(g Grant, t ACLTest, host string, port int) bool {
+	if !e.anyMatches(g.Src, t.Src, "") || !e.postureMatches(g.SrcPosture, t.SrcPostureAttrs) {
+		return false
+	}
+	if !e.anyMatches(g.Dst, host, selfUser(t.Src)) {
+		return false
+	}
+	for _, ip := range g.IP {
+		if ipCapabilityMatches(ip, t.Proto, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *policyEvaluator) anyMatches(selectors []string, id string, self string) bool {
+	for _, sel := range selectors {
+		if e.matches(sel, id, self, nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether sel, a src or dst in a rule, includes id. self is
+// the user whose devices autogroup:self refers to, or empty if there isn't one.
+func (e *policyEvaluator) matches(sel string, id string, self string, seen map[string]bool) bool {
+	switch {
+	case sel == "*" || sel == id:
+		return true
+	case strings.HasPrefix(sel, "group:"):
+		if seen == nil {
+			seen = map[string]bool{}
+		}
+		if seen[sel] {
+			return false
+		}
+		seen[sel] = true
+		for _, member := range e.policy.Groups[sel] {
+			if e.matches(member, id, self, seen) {
+				return true
+			}
+		}
+		return false
+	case sel == "autogroup:member":
+		return isUser(id)
+	case sel == "autogroup:tagged":
+		return isTag(id)
+	case sel == "autogroup:self":
+		return self != "" && id == self
+	case strings.HasPrefix(sel, "autogroup:") || isTag(sel) || isUser(sel):
+		return false
+	}
+
+	idAddrs := e.addresses(id, nil)
+	if len(idAddrs.include) == 0 {
+		return false
+	}
+	return e.addresses(sel, nil).containsAll(idAddrs)
+}
+
+// postureMatches reports whether attrs satisfy any of the postures in
+// srcPosture, or in defaultSrcPosture if srcPosture is empty.
+func (e *policyEvaluator) postureMatches(srcPosture []string, attrs map[string]any) bool {
+	if len(srcPosture) == 0 {
+		srcPosture = e.policy.DefaultSrcPosture
+	}
+	if len(srcPosture) == 0 {
+		return true
+	}
+
+	for _, name := range srcPosture {
+		conditions, ok := e.policy.Postures[name]
+		if !ok {
+			continue
+		}
+		matched := true
+		for _, c := range conditions {
+			if !postureConditionHolds(c, attrs) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// postureConditionHolds evaluates a device posture condition such as
+// "node:os IN ['macos', 'linux']" - https://tailscale.com/kb/1288/device-posture
+func postureConditionHolds(condition string, attrs map[string]any) bool {
+	attr, rest, _ := strings.Cut(strings.TrimSpace(condition), " ")
+	rest = strings.TrimSpace(rest)
+
+	value, set := attrs[attr]
+	switch rest {
+	case "IS SET":
+		return set
+	case "NOT SET":
+		return !set
+	}
+	if !set {
+		return false
+	}
+	actual := fmt.Sprint(value)
+
+	for _, op := range []string{"NOT IN", "IN", "==", "!=", ">=", "<=", ">", "<"} {
+		operand, ok := strings.CutPrefix(rest, op)
+		if !ok {
+			continue
+		}
+		operand = strings.TrimSpace(operand)
+
+		switch op {
+		case "IN", "NOT IN":
+			found := false
+			for _, v := range strings.Split(strings.Trim(operand, "[]"), ",") {
+				if unquotePosture(v) == actual {
+					found = true
+				}
+			}
+			return found == (op == "IN")
+		case "==":
+			return actual == unquotePosture(operand)
+		case "!=":
+			return actual != unquotePosture(operand)
+		}
+
+		cmp := compareVersions(actual, unquotePosture(operand))
+		switch op {
+		case ">=":
+			return cmp >= 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	return false
+}
+
+func unquotePosture(s string) string {
+	return strings.Trim(strings.TrimSpace(s), `'"`)
+}
+
+// compareVersions compares dotted versions such as "1.40.2" numerically,
+// falling back to comparing strings for parts that aren't numbers.
+func compareVersions(a string, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aPart, bPart := "0", "0"
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+
+		aNum, aErr := strconv.Atoi(aPart)
+		bNum, bErr := strconv.Atoi(bPart)
+		switch {
+		case aErr == nil && bErr == nil && aNum != bNum:
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		case (aErr != nil || bErr != nil) && aPart != bPart:
+			return strings.Compare(aPart, bPart)
+		}
+	}
+	return 0
+}
+
+// protoNumbers maps protocol names to IANA protocol numbers -
+// https://tailscale.com/kb/1337/acl-syntax#proto
+var protoNumbers = map[string]string{
+	"igmp":      "2",
+	"ipv4":      "4",
+	"ip-in-ip":  "4",
+	"tcp":       "6",
+	"egp":       "8",
+	"igp":       "9",
+	"udp":       "17",
+	"gre":       "47",
+	"esp":       "50",
+	"ah":        "51",
+	"sctp":      "132",
+	"icmp":      "1",
+	"ipv6-icmp": "58",
+}
+
+func normalizeProto(proto string) string {
+	if n, ok := protoNumbers[strings.ToLower(proto)]; ok {
+		return n
+	}
+	return proto
+}
+
+// protoMatches reports whether a rule with proto applies to testProto. Rules
+// without a proto apply to TCP, UDP and ICMP.
+func protoMatches(proto string, testProto string) bool {
+	testProto = normalizeProto(testProto)
+	if proto == "" {
+		return testProto == "6" || testProto == "17" || testProto == "1"
+	}
+	return normalizeProto(proto) == testProto
+}
+
+// ipCapabilityMatches reports whether an entry of a grant's ip, such as "*",
+// "443", "tcp:80-90" or "icmp:*", allows proto on port.
+func ipCapabilityMatches(ip string, proto string, port int) bool {
+	if ip == "*" {
+		return true
+	}
+	ipProto, ports, ok := strings.Cut(ip, ":")
+	if !ok {
+		if ip != "" && (ip[0] < '0' || ip[0] > '9') {
+			return normalizeProto(ip) == normalizeProto(proto)
+		}
+		return protoMatches("", proto) && portsMatch(ip, port)
+	}
+	return normalizeProto(ipProto) == normalizeProto(proto) && portsMatch(ports, port)
+}
+
+// splitHostPorts splits a rule destination such as "tag:server:22,80" into its
+// host and ports.
+func splitHostPorts(dst string) (string, string, bool) {
+	i := strings.LastIndex(dst, ":")
+	if i == -1 {
+		return "", "", false
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(dst[:i], "["), "]")
+	return host, dst[i+1:], true
+}
+
+func parseTestDestination(dst string) (string, int, error) {
+	host, ports, ok := splitHostPorts(dst)
+	if !ok {
+		return "", 0, fmt.Errorf("invalid destination [%s], expected [host:port]", dst)
+	}
+	port, err := strconv.Atoi(ports)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid destination [%s], expected a single port", dst)
+	}
+	return host, port, nil
+}
+
+// portsMatch reports whether ports, such as "*", "22" or "80,443,8000-8999",
+// includes port.
+func portsMatch(ports string, port int) bool {
+	for _, p := range strings.Split(ports, ",") {
+		if p == "*" {
+			return true
+		}
+		first, last, isRange := strings.Cut(p, "-")
+		if !isRange {
+			last = first
+		}
+		firstPort, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+		lastPort, err := strconv.Atoi(last)
+		if err != nil {
+			continue
+		}
+		if port >= firstPort && port <= lastPort {
+			return true
+		}
+	}
+	return false
+}
+
+func isUser(id string) bool {
+	return strings.Contains(id, "@") && !strings.Contains(id, ":")
+}
+
+func isTag(id string) bool {
+	return strings.HasPrefix(id, "tag:")
+}
+
+// selfUser returns the user whose devices autogroup:self refers to when src is
+// the source of a connection.
+func selfUser(src string) string {
+	if isUser(src) {
+		return src
+	}
+	return ""
+}
+
+// An addressSet is the addresses a name in a policy resolves to.
+type addressSet struct {
+	include []addrRange
+	exclude []addrRange
+}
+
+type addrRange struct {
+	first netip.Addr
+	last  netip.Addr
+}
+
+func (r addrRange) String() string {
+	if r.first == r.last {
+		return r.first.String()
+	}
+	return r.first.String() + "-" + r.last.String()
+}
+
+func (r addrRange) contains(o addrRange) bool {
+	return r.first.BitLen() == o.first.BitLen() && r.first.Compare(o.first) <= 0 && o.last.Compare(r.last) <= 0
+}
+
+func (r addrRange) overlaps(o addrRange) bool {
+	return r.first.BitLen() == o.first.BitLen() && r.first.Compare(o.last) <= 0 && o.first.Compare(r.last) <= 0
+}
+
+// containsAll reports whether every address in o is in s.
+func (s addressSet) containsAll(o addressSet) bool {
+	for _, r := range o.include {
+		contained := false
+		for _, i := range s.include {
+			if i.contains(r) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			return false
+		}
+		for _, x := range s.exclude {
+			if x.overlaps(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// addresses resolves name, a host, ipset, IP address, CIDR or IP range, to the
+// addresses it refers to.
+func (e *policyEvaluator) addresses(name string, seen map[string]bool) addressSet {
+	if seen == nil {
+		seen = map[string]bool{}
+	}
+	if seen[name] {
+		return addressSet{}
+	}
+	seen[name] = true
+
+	if strings.HasPrefix(name, "ipset:") {
+		set := addressSet{}
+		for _, entry := range e.policy.IPSets[name] {
+			if removed, ok := strings.CutPrefix(entry, "remove "); ok {
+				set.exclude = append(set.exclude, e.addresses(strings.TrimSpace(removed), seen).include...)
+				continue
+			}
+			entry = strings.TrimSpace(strings.TrimPrefix(entry, "add "))
+			set.include = append(set.include, e.addresses(entry, seen).include...)
+		}
+		return set
+	}
+
+	if host, ok := e.policy.Hosts[name]; ok {
+		name = host
+	}
+	if r, ok := parseAddrRange(name); ok {
+		return addressSet{include: []addrRange{r}}
+	}
+	return addressSet{}
+}
+
+func parseAddrRange(s string) (addrRange, bool) {
+	if first, last, ok := strings.Cut(s, "-"); ok {
+		firstAddr, err := netip.ParseAddr(first)
+		if err != nil {
+			return addrRange{}, false
+		}
+		lastAddr, err := netip.ParseAddr(last)
+		if err != nil {
+			return addrRange{}, false
+		}
+		return addrRange{first: firstAddr, last: lastAddr}, true
+	}
+
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addrRange{first: addr, last: addr}, true
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return addrRange{}, false
+	}
+	prefix = prefix.Masked()
+	last := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(last)*8; i++ {
+		last[i/8] |= 1 << (7 - i%8)
+	}
+	lastAddr, _ := netip.AddrFromSlice(last)
+	return addrRange{first: prefix.Addr(), last: lastAddr}, true
+}
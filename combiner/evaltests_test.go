@@ -0,0 +1,155 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const EVAL_POLICY = `{
+	"groups": {
+		"group:eng": ["alice@example.com", "group:sre"],
+		"group:sre": ["bob@example.com"],
+	},
+	"hosts": {
+		"vega": "100.64.0.10",
+		"corp": "10.0.0.0/24",
+	},
+	"ipsets": {
+		"ipset:prod": ["corp", "192.0.2.0/24", "remove 192.0.2.128/25"],
+	},
+	"tagOwners": {
+		"tag:web": ["group:eng"],
+		"tag:db":  ["group:eng"],
+	},
+	"postures": {
+		"posture:latestMac": [
+			"node:os IN ['macos', 'linux']",
+			"node:tsVersion >= '1.40'",
+		],
+	},
+	"acls": [
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		{"action": "accept", "src": ["group:eng"], "dst": ["tag:web:80,443"]},
+		{"action": "accept", "src": ["group:sre"], "proto": "udp", "dst": ["vega:53"]},
+		{"action": "accept", "src": ["autogroup:member"], "dst": ["autogroup:self:*"]},
+		// acl-combiner: from ` + "`child.hujson`" + `
+		{"action": "accept", "src": ["alice@example.com"], "dst": ["ipset:prod:22"], "srcPosture": ["posture:latestMac"]},
+	],
+	"grants": [
+		// acl-combiner: from ` + "`grants.hujson`" + `
+		{"src": ["tag:web"], "dst": ["tag:db"], "ip": ["tcp:5432"]},
+		{"src": ["bob@example.com"], "dst": ["tag:db"], "app": {"example.com/cap/db": [{}]}},
+	],
+}`
+
+func evalTestsWith(t *testing.T, tests string) Diagnostics {
+	t.Helper()
+	policy := strings.Replace(EVAL_POLICY, `	"grants": [`, `	"tests": [
+		// acl-combiner: from `+"`tests.hujson`"+`
+		`+tests+`
+	],
+	"grants": [`, 1)
+
+	doc, err := jwcc.Parse(strings.NewReader(policy))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	return EvaluateTests(doc.Value.(*jwcc.Object))
+}
+
+func TestEvaluateTestsPass(t *testing.T) {
+	diags := evalTestsWith(t, `
+		{"src": "alice@example.com", "accept": ["tag:web:443"], "deny": ["tag:web:22", "tag:db:5432"]},
+		{"src": "bob@example.com", "accept": ["tag:web:80", "bob@example.com:22"], "deny": ["alice@example.com:22"]},
+		{"src": "bob@example.com", "proto": "udp", "accept": ["vega:53", "100.64.0.10:53"]},
+		{"src": "bob@example.com", "deny": ["vega:53"]},
+		{"src": "tag:web", "accept": ["tag:db:5432"], "deny": ["tag:db:5433"]},
+		{"src": "bob@example.com", "deny": ["tag:db:5432"]},
+		{
+			"src": "alice@example.com",
+			"srcPostureAttrs": {"node:os": "macos", "node:tsVersion": "1.62.0"},
+			"accept": ["10.0.0.5:22", "192.0.2.1:22"],
+			"deny": ["192.0.2.200:22", "10.0.1.1:22"],
+		},
+		{
+			"src": "alice@example.com",
+			"srcPostureAttrs": {"node:os": "windows", "node:tsVersion": "1.62.0"},
+			"deny": ["10.0.0.5:22"],
+		},
+		{"src": "alice@example.com", "deny": ["10.0.0.5:22"]},
+	`)
+	if len(diags) != 0 {
+		t.Fatalf("expected no failures, got [%v]", diags)
+	}
+}
+
+func TestEvaluateTestsFailures(t *testing.T) {
+	diags := evalTestsWith(t, `
+		{"src": "carol@example.com", "accept": ["tag:web:443"]},
+		{"src": "alice@example.com", "deny": ["tag:web:443"]},
+		{"src": "alice@example.com", "accept": ["tag:missing:443", "tag:web"]},
+	`)
+
+	expected := []string{
+		"tests.hujson:34:3: test failed: [carol@example.com] cannot access [tag:web:443] over [tcp], expected accept",
+		"tests.hujson:35:3: test failed: [alice@example.com] can access [tag:web:443] over [tcp], allowed by acls[0] from [parent.hujson], expected deny",
+		"tests.hujson:36:3: test failed: tag [tag:missing] in [tag:missing:443] is not defined in tagOwners",
+		"tests.hujson:36:3: test failed: [alice@example.com] cannot access [tag:missing:443] over [tcp], expected accept",
+		"tests.hujson:36:3: test failed: invalid destination [tag:web], expected a single port",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] failures, got [%v]", len(expected), diags)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] || d.Rule != RuleACLTest {
+			t.Fatalf("failure [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+}
+
+func TestPostureConditionHolds(t *testing.T) {
+	attrs := map[string]any{
+		"node:os":             "linux",
+		"node:tsVersion":      "1.40.2",
+		"node:tsAutoUpdate":   true,
+		"node:tsReleaseTrack": "stable",
+	}
+	tests := map[string]bool{
+		"node:os IN ['macos', 'linux']":     true,
+		"node:os NOT IN ['macos', 'linux']": false,
+		"node:os == 'linux'":                true,
+		"node:os != 'linux'":                false,
+		"node:tsVersion >= '1.40'":          true,
+		"node:tsVersion > '1.40.10'":        false,
+		"node:tsVersion < '1.100'":          true,
+		"node:tsAutoUpdate == true":         true,
+		"node:tsReleaseTrack IS SET":        true,
+		"custom:missing NOT SET":            true,
+		"custom:missing == 'x'":             false,
+	}
+	for condition, expected := range tests {
+		if got := postureConditionHolds(condition, attrs); got != expected {
+			t.Fatalf("condition [%s] should be [%v], got [%v]", condition, expected, got)
+		}
+	}
+}
+
+func TestPortsMatch(t *testing.T) {
+	if !portsMatch("*", 22) || !portsMatch("80,443", 443) || !portsMatch("8000-8999", 8080) {
+		t.Fatalf("expected ports to match")
+	}
+	if portsMatch("80,443", 22) || portsMatch("8000-8999", 9000) {
+		t.Fatalf("expected ports not to match")
+	}
+}
+
+func TestIPCapabilityMatches(t *testing.T) {
+	if !ipCapabilityMatches("*", "udp", 53) || !ipCapabilityMatches("443", "tcp", 443) || !ipCapabilityMatches("tcp:80-90", "tcp", 85) || !ipCapabilityMatches("icmp", "icmp", 0) {
+		t.Fatalf("expected ip to match")
+	}
+	if ipCapabilityMatches("tcp:443", "udp", 443) || ipCapabilityMatches("443", "sctp", 443) {
+		t.Fatalf("expected ip not to match")
+	}
+}
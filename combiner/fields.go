@@ -0,0 +1,122 @@
+package combiner
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// requiredFields are the fields each entry of an array section must have, by
+// lower case section name. A required field may be written under any of its
+// alternative names, such as the legacy users and ports of acls.
+var requiredFields = map[string][][]string{
+	"acls":            {{"action"}, {"src", "users"}, {"dst", "ports"}},
+	"grants":          {{"src"}, {"dst"}},
+	"ssh":             {{"action"}, {"src"}, {"dst"}, {"users"}},
+	"tests":           {{"src"}},
+	"sshtests":        {{"src"}, {"dst"}},
+	"nodeattrs":       {{"target"}},
+	"extradnsrecords": {{"Name"}, {"Value"}},
+}
+
+// knownFields returns the JSON names of the fields of t, a struct.
+func knownFields(t reflect.Type) []string {
+	fields := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// checkFields returns a warning for each member of v, an entry at where in
+// section, that isn't a field of T, and an error for each required field of
+// the section v doesn't have. Unknown fields are only warnings, so a field
+// Tailscale added since doesn't fail the run. Field names are matched
+// case-insensitively, as when decoding.
+func checkFields[T any](path string, section string, where string, v jwcc.Value) Diagnostics {
+	obj, ok := v.(*jwcc.Object)
+	if !ok {
+		return nil
+	}
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	known := knownFields(t)
+
+	diags := Diagnostics{}
+	for _, m := range obj.Members {
+		key := m.Key.String()
+		if containsFold(known, key) {
+			continue
+		}
+		d := diagnosticAt(path, m, RuleUnknownField, "unknown field [%s] in %s, expected one of [%s]", key, where, strings.Join(known, ", "))
+		if suggestion := closestField(known, key); suggestion != "" {
+			d = diagnosticAt(path, m, RuleUnknownField, "unknown field [%s] in %s, did you mean [%s]?", key, where, suggestion)
+		}
+		d.Severity = SeverityWarning
+		diags = append(diags, d)
+	}
+
+	for _, names := range requiredFields[strings.ToLower(section)] {
+		if slices.ContainsFunc(names, func(name string) bool { return obj.Find(name) != nil }) {
+			continue
+		}
+		diags = append(diags, diagnosticAt(path, obj, RuleMissingField, "missing required field [%s] in %s", strings.Join(names, "] or ["), where))
+	}
+	return diags
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestField returns the field in known closest to key, if it's close enough
+// to be a typo, such as "scr" for "src".
+func closestField(known []string, key string) string {
+	closest := ""
+	best := 0
+	for _, field := range known {
+		d := editDistance(strings.ToLower(field), strings.ToLower(key))
+		if closest == "" || d < best {
+			closest, best = field, d
+		}
+	}
+	// allow a third of the field to differ, and at least 2 edits for short
+	// fields, so swapped letters in "dts" or "scr" are caught
+	if best > max(2, len(closest)/3) {
+		return ""
+	}
+	return closest
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,93 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestCheckFields(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			// acl-combiner: from ` + "`child.hujson`" + `
+			{"action": "accept", "scr": ["*"], "dts": ["*:*"]},
+			{"Action": "accept", "src": ["*"], "dst": ["*:*"], "comment": "all"},
+		],
+		"grants": [
+			// acl-combiner: from ` + "`child.hujson`" + `
+			{"src": ["*"], "dst": ["*"], "ips": ["*"]},
+		],
+		"extraDNSRecords": [
+			// acl-combiner: from ` + "`parent.hujson`" + `
+			{"name": "db.example.com"},
+		],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	_, diags := DecodePolicy(doc.Value.(*jwcc.Object))
+	expected := []string{
+		"child.hujson:4:4: missing required field [src] or [users] in acls[0]",
+		"child.hujson:4:4: missing required field [dst] or [ports] in acls[0]",
+		"child.hujson:4:25: unknown field [scr] in acls[0], did you mean [src]?",
+		"child.hujson:4:39: unknown field [dts] in acls[0], did you mean [dst]?",
+		"child.hujson:5:55: unknown field [comment] in acls[1], expected one of [action, proto, src, dst, srcPosture, users, ports]",
+		"child.hujson:9:33: unknown field [ips] in grants[0], did you mean [ip]?",
+		"parent.hujson:13:4: missing required field [Value] in extraDNSRecords[0]",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), diags)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] {
+			t.Errorf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+		if (d.Rule == RuleUnknownField) != (d.Severity == SeverityWarning) {
+			t.Errorf("only unknown fields should be warnings, got [%s] for [%s]", d.Severity, d)
+		}
+	}
+}
+
+func TestCheckFieldsLegacyFields(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			{"action": "accept", "users": ["group:eng"], "ports": ["tag:server:22"]},
+		],
+		"nodeAttrs": [
+			{"target": ["tag:server"], "ipPool": ["100.81.0.0/16"]},
+		],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	policy, diags := DecodePolicy(doc.Value.(*jwcc.Object))
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got [%v]", diags)
+	}
+	if len(policy.ACLs) != 1 || policy.ACLs[0].Src[0] != "group:eng" || policy.ACLs[0].Dst[0] != "tag:server:22" {
+		t.Fatalf("expected users and ports to be decoded as src and dst, got [%+v]", policy.ACLs)
+	}
+}
+
+func TestClosestField(t *testing.T) {
+	known := []string{"action", "src", "dst", "srcPosture"}
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"scr", "src"},
+		{"dts", "dst"},
+		{"actoin", "action"},
+		{"srcPostures", "srcPosture"},
+		{"users", ""},
+		{"comment", ""},
+	}
+	for _, tt := range tests {
+		if got := closestField(known, tt.key); got != tt.expected {
+			t.Errorf("closest field to [%s] should be [%s], got [%s]", tt.key, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package combiner
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// A sectionGuard checks a child's section against the PathRule matching the
+// child, and the sections merged into parent so far, before the section is
+// merged.
+type sectionGuard func(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) Diagnostics
+
+var sectionGuards = []sectionGuard{
+	checkTagNamespace,
+	checkGroupOwnership,
+	checkAllocations,
+	checkRouteOverlaps,
+}
+
+// guardHandler wraps next so that a child's section is only merged if it
+// passes every sectionGuard.
+func guardHandler(next SectionHandler) SectionHandler {
+	return func(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) error {
+		diags := Diagnostics{}
+		for _, guard := range sectionGuards {
+			diags = append(diags, guard(ctx, sectionKey, parent, childSection)...)
+		}
+		if len(diags) > 0 {
+			return diags
+		}
+		return next(ctx, sectionKey, parent, childSection)
+	}
+}
+
+// OwnsTag reports whether tag is in the rule's tag namespace.
+func (r PathRule) OwnsTag(tag string) bool {
+	if len(r.Tags) == 0 {
+		return true
+	}
+	for _, pattern := range r.Tags {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTagNamespace rejects tagOwners keys, src and dst tags, and
+// autoApprovers approvers outside the tag namespace of the most specific
+// PathRule with tags matching the child.
+func checkTagNamespace(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) Diagnostics {
+	rule := ctx.ruleFor(func(r PathRule) bool { return len(r.Tags) > 0 })
+	if rule == nil {
+		return nil
+	}
+
+	diags := Diagnostics{}
+	for _, ref := range tagReferences(sectionKey, childSection.Value) {
+		if !rule.OwnsTag(ref.Tag) {
+			diags = append(diags, diagnosticAt(ctx.ChildPath, ref.Value, RuleTagNamespace, "tag [%s] in %s is outside the tag namespace [%s] of [%s]", ref.Tag, ref.Where, strings.Join(rule.Tags, ","), rule.Pattern))
+		}
+	}
+	return diags
+}
+
+// groupOwner returns the most specific rule whose groups include group, or the
+// later rule if two are equally specific, or nil if no rule owns it.
+func groupOwner(rules []PathRule, group string) *PathRule {
+	var owner *PathRule
+	for i := range rules {
+		owns := slices.ContainsFunc(rules[i].Groups, func(pattern string) bool {
+			ok, _ := path.Match(pattern, group)
+			return ok
+		})
+		if owns && (owner == nil || rules[i].specificity() >= owner.specificity()) {
+			owner = &rules[i]
+		}
+	}
+	return owner
+}
+
+// checkGroupOwnership rejects groups a child defines or extends without
+// owning them, once any rule has groups.
+func checkGroupOwnership(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) Diagnostics {
+	if sectionKey != "groups" || !slices.ContainsFunc(ctx.rules, func(r PathRule) bool { return len(r.Groups) > 0 }) {
+		return nil
+	}
+	obj, ok := childSection.Value.(*jwcc.Object)
+	if !ok {
+		return nil
+	}
+
+	diags := Diagnostics{}
+	for _, m := range obj.Members {
+		group := m.Key.String()
+		owner := groupOwner(ctx.rules, group)
+		if owner != nil && owner.matchesAny(ctx.childPaths) {
+			continue
+		}
+
+		ownerName := ctx.ParentPath
+		if owner != nil {
+			ownerName = owner.Pattern
+		}
+		diags = append(diags, diagnosticAt(ctx.ChildPath, m, RuleGroupOwnership, "group [%s] is owned by [%s], it cannot be defined or extended by [%s]", group, ownerName, ctx.ChildPath))
+	}
+	return diags
+}
+
+// A tagReference is a tag used in a section, and where it was used.
+type tagReference struct {
+	Tag   string
+	Where string
+	Value jwcc.Value
+}
+
+// tagReferences returns the tags owned by tagOwners keys, used in the src and
+// dst of acls, grants and ssh, and approving autoApprovers routes, services
+// and exit nodes.
+func tagReferences(sectionKey string, v jwcc.Value) []tagReference {
+	refs := []tagReference{}
+	switch sectionKey {
+	case "tagOwners":
+		obj, ok := v.(*jwcc.Object)
+		if !ok {
+			return nil
+		}
+		for _, m := range obj.Members {
+			if isTag(m.Key.String()) {
+				refs = append(refs, tagReference{Tag: m.Key.String(), Where: fmt.Sprintf("%s[%q]", sectionKey, m.Key.String()), Value: m})
+			}
+		}
+
+	case "acls", "grants", "ssh":
+		arr, ok := v.(*jwcc.Array)
+		if !ok {
+			return nil
+		}
+		for i, item := range arr.Values {
+			rule, ok := item.(*jwcc.Object)
+			if !ok {
+				continue
+			}
+			fields := []string{"src", "dst"}
+			if sectionKey == "acls" {
+				// the legacy names of src and dst
+				fields = append(fields, "users", "ports")
+			}
+			for _, field := range fields {
+				m := rule.Find(field)
+				if m == nil {
+					continue
+				}
+				for _, d := range stringValues(m.Value) {
+					tag := d.Value.(ast.Text).String()
+					if hasPorts(sectionKey, field) {
+						tag, _, _ = splitHostPorts(tag)
+					}
+					if isTag(tag) {
+						refs = append(refs, tagReference{Tag: tag, Where: fmt.Sprintf("%s[%d].%s", sectionKey, i, field), Value: d})
+					}
+				}
+			}
+		}
+
+	case "autoApprovers":
+		obj, ok := v.(*jwcc.Object)
+		if !ok {
+			return nil
+		}
+		for _, m := range obj.Members {
+			approvers := map[string]jwcc.Value{fmt.Sprintf("%s.%s", sectionKey, m.Key): m.Value}
+			if inner, ok := m.Value.(*jwcc.Object); ok {
+				approvers = map[string]jwcc.Value{}
+				for _, a := range inner.Members {
+					approvers[fmt.Sprintf("%s.%s[%q]", sectionKey, m.Key, a.Key.String())] = a.Value
+				}
+			}
+			for where, v := range approvers {
+				for _, d := range stringValues(v) {
+					tag := d.Value.(ast.Text).String()
+					if isTag(tag) {
+						refs = append(refs, tagReference{Tag: tag, Where: where, Value: d})
+					}
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// stringValues returns the strings in v, which may be a string or an array.
+func stringValues(v jwcc.Value) []*jwcc.Datum {
+	values := []jwcc.Value{v}
+	if arr, ok := v.(*jwcc.Array); ok {
+		values = arr.Values
+	}
+
+	datums := []*jwcc.Datum{}
+	for _, v := range values {
+		if d, ok := v.(*jwcc.Datum); ok {
+			if _, isText := d.Value.(ast.Text); isText {
+				datums = append(datums, d)
+			}
+		}
+	}
+	return datums
+}
@@ -0,0 +1,233 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func mergeChildWithRule(t *testing.T, childPath string, child string, rule PathRule) error {
+	t.Helper()
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc, err := jwcc.Parse(strings.NewReader(child))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	sections, err := DefaultRegistry().Allow([]string{"acls", "autoApprovers", "grants", "groups", "ipsets", "ssh", "tagOwners"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	return Merge(
+		&ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"},
+		[]*ParsedDocument{{Object: childDoc.Value.(*jwcc.Object), Path: childPath}},
+		Options{Sections: sections, Rules: []PathRule{rule}},
+	)
+}
+
+func TestPathRuleOwnsTag(t *testing.T) {
+	rule := PathRule{Pattern: "departments/finance", Tags: []string{"tag:finance*", "tag:fin-?"}}
+	for _, tag := range []string{"tag:finance", "tag:finance-db", "tag:fin-a"} {
+		if !rule.OwnsTag(tag) {
+			t.Fatalf("tag [%s] should be in namespace", tag)
+		}
+	}
+	for _, tag := range []string{"tag:demo-infra", "tag:fin-ab"} {
+		if rule.OwnsTag(tag) {
+			t.Fatalf("tag [%s] should not be in namespace", tag)
+		}
+	}
+
+	if !(PathRule{Pattern: "departments"}).OwnsTag("tag:anything") {
+		t.Fatalf("rule without tags should allow any tag")
+	}
+}
+
+func TestTagNamespaceAllowed(t *testing.T) {
+	err := mergeChildWithRule(t, "departments/finance/policy.hujson", `{
+		"tagOwners": {"tag:finance": ["group:finance"]},
+		"acls": [{"action": "accept", "src": ["tag:finance"], "dst": ["tag:finance-db:5432", "group:finance:*"]}],
+		"ssh": [{"action": "accept", "src": ["group:finance"], "dst": ["tag:finance"], "users": ["root"]}],
+		"autoApprovers": {"routes": {"10.0.10.0/32": ["tag:finance"]}, "exitNode": ["tag:finance"]},
+	}`, PathRule{Pattern: "departments/finance", Tags: []string{"tag:finance*"}})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+}
+
+func TestTagNamespaceViolations(t *testing.T) {
+	err := mergeChildWithRule(t, "departments/finance/policy.hujson", `{
+		"tagOwners": {"tag:demo-infra": ["group:finance"]},
+		"acls": [{"action": "accept", "src": ["group:finance"], "dst": ["tag:demo-infra:22"]}],
+		"grants": [{"src": ["tag:prod"], "dst": ["tag:finance"], "ip": ["*"]}],
+		"ssh": [{"action": "accept", "src": ["group:finance"], "dst": ["tag:demo-infra"], "users": ["root"]}],
+		"autoApprovers": {"routes": {"10.0.10.0/32": ["tag:router"]}, "exitNode": ["tag:exit"]},
+	}`, PathRule{Pattern: "departments/finance", Tags: []string{"tag:finance*"}})
+
+	diags := AsDiagnostics(err)
+	expected := []string{
+		`departments/finance/policy.hujson:2:17: tag [tag:demo-infra] in tagOwners["tag:demo-infra"] is outside the tag namespace [tag:finance*] of [departments/finance]`,
+		`departments/finance/policy.hujson:3:67: tag [tag:demo-infra] in acls[0].dst is outside the tag namespace [tag:finance*] of [departments/finance]`,
+		`departments/finance/policy.hujson:4:23: tag [tag:prod] in grants[0].src is outside the tag namespace [tag:finance*] of [departments/finance]`,
+		`departments/finance/policy.hujson:5:66: tag [tag:demo-infra] in ssh[0].dst is outside the tag namespace [tag:finance*] of [departments/finance]`,
+		`departments/finance/policy.hujson:6:49: tag [tag:router] in autoApprovers.routes["10.0.10.0/32"] is outside the tag namespace [tag:finance*] of [departments/finance]`,
+		`departments/finance/policy.hujson:6:78: tag [tag:exit] in autoApprovers.exitNode is outside the tag namespace [tag:finance*] of [departments/finance]`,
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), err)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] || d.Rule != RuleTagNamespace {
+			t.Fatalf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+}
+
+func TestTagNamespaceRuleWithoutSections(t *testing.T) {
+	// a rule with only tags uses the default sections
+	err := mergeChildWithRule(t, "departments/finance/policy.hujson", `{
+		"tagOwners": {"tag:finance": []},
+	}`, PathRule{Pattern: "departments/finance", Tags: []string{"tag:finance*"}})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+}
+
+func TestGroupOwnership(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:admins": ["admin@example.com"],
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	engineering, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:engineering": ["user1@example.com"],
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	finance, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:finance":     ["finance@example.com"],
+			"group:engineering": ["finance@example.com"],
+			"group:admins":      ["finance@example.com"],
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	sections, err := DefaultRegistry().Allow([]string{"groups"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	parentDoc := &ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"}
+	err = Merge(parentDoc, []*ParsedDocument{
+		{Object: engineering.Value.(*jwcc.Object), Path: "departments/engineering/groups.hujson"},
+		{Object: finance.Value.(*jwcc.Object), Path: "departments/finance/groups.hujson"},
+	}, Options{
+		Sections: sections,
+		Rules: []PathRule{
+			{Pattern: "departments/engineering", Groups: []string{"group:engineering*"}},
+			{Pattern: "departments/finance", Groups: []string{"group:finance"}},
+		},
+	})
+
+	diags := AsDiagnostics(err)
+	expected := []string{
+		"departments/finance/groups.hujson:4:4: group [group:engineering] is owned by [departments/engineering], it cannot be defined or extended by [departments/finance/groups.hujson]",
+		"departments/finance/groups.hujson:5:4: group [group:admins] is owned by [parent], it cannot be defined or extended by [departments/finance/groups.hujson]",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), err)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] || d.Rule != RuleGroupOwnership {
+			t.Fatalf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+
+	engineeringMembers := parentDoc.Object.Find("groups").Value.(*jwcc.Object).Find("group:engineering").Value.(*jwcc.Array).Values
+	if len(engineeringMembers) != 1 {
+		t.Fatalf("group [group:engineering] should not be extended, got [%v]", len(engineeringMembers))
+	}
+}
+
+func TestGroupOwnershipDisabled(t *testing.T) {
+	// without any rule owning groups, children may extend any group
+	err := mergeChildWithRule(t, "departments/finance/groups.hujson", `{
+		"groups": {"group:engineering": ["finance@example.com"]},
+	}`, PathRule{Pattern: "departments/finance", Tags: []string{"tag:finance*"}})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+}
+
+func TestPathRuleControlsResolvedSeparately(t *testing.T) {
+	registry := DefaultRegistry()
+	sections, err := registry.Allow([]string{"acls", "groups"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	aclsOnly, err := registry.Allow([]string{"acls"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	merge := func(rules []PathRule) Diagnostics {
+		t.Helper()
+		parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		child, err := jwcc.Parse(strings.NewReader(`{
+			"acls":   [{"action": "accept", "src": ["group:finance"], "dst": ["tag:infra:22"]}],
+			"groups": {"group:finance": ["finance@example.com"]},
+		}`))
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		return AsDiagnostics(Merge(
+			&ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"},
+			[]*ParsedDocument{{Object: child.Value.(*jwcc.Object), Path: "kids/departments/finance/policy.hujson"}},
+			Options{Sections: sections, Rules: rules},
+		))
+	}
+
+	// the subdirectory's sections don't turn off the parent directory's tag namespace
+	diags := merge([]PathRule{
+		{Pattern: "kids", Tags: []string{"tag:finance*"}},
+		{Pattern: "kids/departments/finance", Sections: sections},
+	})
+	if len(diags) != 1 || diags[0].Rule != RuleTagNamespace {
+		t.Fatalf("expected a tag namespace diagnostic, got [%v]", diags)
+	}
+
+	// the subdirectory's tag namespace doesn't turn off the parent directory's sections
+	diags = merge([]PathRule{
+		{Pattern: "kids", Sections: aclsOnly},
+		{Pattern: "kids/departments/finance", Tags: []string{"tag:infra*"}},
+	})
+	if len(diags) != 1 || diags[0].Rule != RuleUnsupportedSection || !strings.Contains(diags[0].Message, "rule [kids=acls]") {
+		t.Fatalf("expected groups to be denied by [kids=acls], got [%v]", diags)
+	}
+
+	// the most specific rule wins regardless of order
+	diags = merge([]PathRule{
+		{Pattern: "kids/departments/finance", Sections: sections, Tags: []string{"tag:infra*"}},
+		{Pattern: "kids", Sections: aclsOnly, Tags: []string{"tag:finance*"}},
+	})
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got [%v]", diags)
+	}
+}
@@ -0,0 +1,390 @@
+package combiner
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// A SectionHandler merges childSection, the section named sectionKey in the
+// child file described by ctx, into parent.
+type SectionHandler func(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) error
+
+// A Registry maps section names to the handler merging them.
+type Registry map[string]SectionHandler
+
+// DefaultRegistry returns the handlers for every supported section, keyed by
+// Tailscale's spelling of the section name.
+func DefaultRegistry() Registry {
+	return Registry{
+		"acls":            HandleArray(),
+		"autoApprovers":   HandleAutoApprovers(ConflictUnion),
+		"extraDNSRecords": HandleArray(),
+		"grants":          HandleArray(),
+		"groups":          HandleObject(ConflictUnion),
+		"ipsets":          HandleObject(ConflictUnion),
+		"nodeAttrs":       HandleNodeAttrs(),
+		"postures":        HandleObject(ConflictUnion),
+		"ssh":             HandleArray(),
+		"tagOwners":       HandleObject(ConflictUnion),
+		"tests":           HandleArray(),
+		"sshTests":        HandleArray(),
+		"hosts":           HandleObject(ConflictUnion),
+
+		// network-wide options - https://tailscale.com/kb/1337/acl-syntax#network-policy-options
+		"derpMap":             HandleDERPMap(),
+		"disableIPv4":         HandleValue(),
+		"OneCGNATRoute":       HandleValue(),
+		"randomizeClientPort": HandleValue(),
+	}
+}
+
+// sections whose members are keyed by name, and so can have conflicting keys across files
+var objectSectionHandlers = map[string]func(ConflictStrategy) SectionHandler{
+	"autoApprovers": HandleAutoApprovers,
+	"groups":        HandleObject,
+	"hosts":         HandleObject,
+	"ipsets":        HandleObject,
+	"postures":      HandleObject,
+	"tagOwners":     HandleObject,
+}
+
+// Allow returns the subset of r for the named sections. Names are matched
+// case-insensitively, as policy keys are.
+func (r Registry) Allow(sections []string) (Registry, error) {
+	allowed := Registry{}
+	for _, v := range sections {
+		name := r.canonicalName(v)
+		if name == "" {
+			return nil, fmt.Errorf("unsupported section [%s]", v)
+		}
+		allowed[name] = r[name]
+	}
+	return allowed, nil
+}
+
+// canonicalName returns the name section is registered as in r, matching
+// case-insensitively, or "" if it isn't registered.
+func (r Registry) canonicalName(section string) string {
+	if r[section] != nil {
+		return section
+	}
+	for name := range r {
+		if strings.EqualFold(name, section) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Names returns the sorted section names in r.
+func (r Registry) Names() []string {
+	return slices.Sorted(maps.Keys(r))
+}
+
+// SetConflictStrategy replaces the handler for section with one using strategy.
+func (r Registry) SetConflictStrategy(section string, strategy ConflictStrategy) error {
+	name := r.canonicalName(section)
+	if name == "" {
+		return fmt.Errorf("unsupported section [%s]", section)
+	}
+	section = name
+	handlerFn := objectSectionHandlers[section]
+	if handlerFn == nil {
+		return fmt.Errorf("conflict strategies are not supported for section [%s]", section)
+	}
+	r[section] = handlerFn(strategy)
+	return nil
+}
+
+// A ConflictStrategy decides what happens when more than one file defines the
+// same key in an object section.
+type ConflictStrategy string
+
+const (
+	// ConflictError fails when more than one file defines the same key.
+	ConflictError ConflictStrategy = "error"
+	// ConflictUnion merges arrays with the same key and fails on any other differing values.
+	ConflictUnion ConflictStrategy = "union"
+	// ConflictParentWins keeps the parent's value, or the first child's if the parent doesn't define the key.
+	ConflictParentWins ConflictStrategy = "parent-wins"
+	// ConflictFirstChildWins keeps the first child's value, replacing the parent's value.
+	ConflictFirstChildWins ConflictStrategy = "first-child-wins"
+)
+
+// ParseConflictStrategy returns the ConflictStrategy named s.
+func ParseConflictStrategy(s string) (ConflictStrategy, error) {
+	switch ConflictStrategy(s) {
+	case ConflictError, ConflictUnion, ConflictParentWins, ConflictFirstChildWins:
+		return ConflictStrategy(s), nil
+	}
+	return "", fmt.Errorf("unsupported conflict strategy [%s]", s)
+}
+
+// HandleArray appends the values of a child's array section to the parent's.
+func HandleArray() SectionHandler {
+	return func(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+
+		childArr, ok := childSection.Value.(*jwcc.Array)
+		if !ok {
+			return ctx.Errorf(childSection, RuleInvalidType, "section [%s] must be an array, got %s", sectionKey, valueKind(childSection.Value))
+		}
+
+		newArr, err := existingOrNewArray(ctx, *parent, sectionKey)
+		if err != nil {
+			return err
+		}
+
+		pathCommentAlreadyAdded := false
+		for _, v := range childArr.Values {
+			newArr.Values = append(newArr.Values, v)
+
+			if !pathCommentAlreadyAdded {
+				pathComment(v, ctx.ChildPath)
+				pathCommentAlreadyAdded = true
+			}
+		}
+
+		upsertMember(parent, sectionKey, newArr)
+		return nil
+	}
+}
+
+// HandleObject adds the members of a child's object section to the parent's,
+// resolving keys defined in both with strategy.
+func HandleObject(strategy ConflictStrategy) SectionHandler {
+	return func(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+
+		childObj, ok := childSection.Value.(*jwcc.Object)
+		if !ok {
+			return ctx.Errorf(childSection, RuleInvalidType, "section [%s] must be an object, got %s", sectionKey, valueKind(childSection.Value))
+		}
+
+		newObj, err := existingOrNewObject(ctx, *parent, sectionKey)
+		if err != nil {
+			return err
+		}
+
+		diags := Diagnostics{}
+		for _, m := range childObj.Members {
+			existingMemberIdx := newObj.IndexKey(ast.TextEqual(m.Key.String()))
+			if existingMemberIdx != -1 {
+				err := resolveConflict(ctx, strategy, sectionKey, newObj.Members[existingMemberIdx], m)
+				if err != nil {
+					diags = append(diags, AsDiagnostics(err)...)
+				}
+				continue
+			}
+
+			newMember := copyMember(m)
+			newObj.Members = append(newObj.Members, newMember)
+
+			pathComment(newMember, ctx.ChildPath)
+		}
+
+		upsertMember(parent, sectionKey, newObj)
+		if len(diags) > 0 {
+			return diags
+		}
+		return nil
+	}
+}
+
+func resolveConflict(ctx *MergeContext, strategy ConflictStrategy, sectionKey string, existingMember *jwcc.Member, m *jwcc.Member) error {
+	existingSources := sourcesFromComments(existingMember.Comments().Before)
+	if len(existingSources) == 0 {
+		existingSources = []string{ctx.ParentPath}
+	}
+	fromParent := existingSources[0] == ctx.ParentPath
+
+	switch strategy {
+	case ConflictError:
+		return ctx.Errorf(m, RuleConflictingKey, "conflicting key [\"%s\"] in section [%s] defined in [%s] and [%s]", m.Key, sectionKey, existingSources[0], ctx.ChildPath)
+
+	case ConflictParentWins:
+		ctx.Logf("keeping [%s] in section [%s] from [%s], ignoring [%s]\n", m.Key, sectionKey, existingSources[0], ctx.ChildPath)
+		return nil
+
+	case ConflictFirstChildWins:
+		if !fromParent {
+			ctx.Logf("keeping [%s] in section [%s] from [%s], ignoring [%s]\n", m.Key, sectionKey, existingSources[0], ctx.ChildPath)
+			return nil
+		}
+		ctx.Logf("replacing [%s] in section [%s] from [%s] with [%s]\n", m.Key, sectionKey, ctx.ParentPath, ctx.ChildPath)
+		existingMember.Value = m.Value
+		*existingMember.Comments() = *m.Comments()
+		pathComment(existingMember, ctx.ChildPath)
+		return nil
+	}
+
+	existingArr, existingIsArr := existingMember.Value.(*jwcc.Array)
+	newArr, newIsArr := m.Value.(*jwcc.Array)
+
+	if existingIsArr && newIsArr {
+		mergedArr := mergeArraysWithDedup(existingArr, newArr)
+
+		if existingComments := existingArr.Comments(); existingComments != nil {
+			if mergedComments := mergedArr.Comments(); mergedComments != nil {
+				mergedComments.Before = existingComments.Before
+				mergedComments.Line = existingComments.Line
+				mergedComments.End = existingComments.End
+			}
+		}
+
+		existingMember.Value = mergedArr
+
+		addMergeComment(existingMember, ctx.ParentPath, ctx.ChildPath)
+		addAuthorComments(existingMember, m)
+		return nil
+	}
+
+	if existingMember.Value.JSON() == m.Value.JSON() {
+		addMergeComment(existingMember, ctx.ParentPath, ctx.ChildPath)
+		addAuthorComments(existingMember, m)
+		return nil
+	}
+
+	return ctx.Errorf(m, RuleConflictingKey, "cannot merge key [\"%s\"] in section [%s] with different values defined in [%s] and [%s]", m.Key, sectionKey, existingSources[0], ctx.ChildPath)
+}
+
+func mergeArraysWithDedup(existing *jwcc.Array, new *jwcc.Array) *jwcc.Array {
+	result := &jwcc.Array{
+		Values: make([]jwcc.Value, 0, len(existing.Values)+len(new.Values)),
+	}
+
+	seen := make(map[string]bool)
+
+	for _, v := range existing.Values {
+		key := v.String()
+		if !seen[key] {
+			seen[key] = true
+			result.Values = append(result.Values, v)
+		}
+	}
+
+	for _, v := range new.Values {
+		key := v.String()
+		if !seen[key] {
+			seen[key] = true
+			result.Values = append(result.Values, v)
+		}
+	}
+
+	return result
+}
+
+// HandleAutoApprovers merges the exitNode, routes and services of a child's
+// autoApprovers, resolving routes and services defined in both with strategy.
+func HandleAutoApprovers(strategy ConflictStrategy) SectionHandler {
+	// https://tailscale.com/kb/1337/acl-syntax#auto-approvers-autoapprovers
+	return func(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+		childSectionObj, ok := childSection.Value.(*jwcc.Object)
+		if !ok {
+			return ctx.Errorf(childSection, RuleInvalidType, "section [%s] must be an object, got %s", sectionKey, valueKind(childSection.Value))
+		}
+
+		newObj, err := existingOrNewObject(ctx, *parent, sectionKey)
+		if err != nil {
+			return err
+		}
+
+		diags := Diagnostics{}
+
+		childExitNodeProps := childSectionObj.FindKey(ast.TextEqual("exitNode"))
+		arrayFn := HandleArray()
+		err = arrayFn(ctx, "exitNode", newObj, childExitNodeProps)
+		diags = append(diags, AsDiagnostics(err)...)
+
+		childRoutesProps := childSectionObj.FindKey(ast.TextEqual("routes"))
+		objectFn := HandleObject(strategy)
+		err = objectFn(ctx, "routes", newObj, childRoutesProps)
+		diags = append(diags, AsDiagnostics(err)...)
+
+		childServicesProps := childSectionObj.FindKey(ast.TextEqual("services"))
+		err = objectFn(ctx, "services", newObj, childServicesProps)
+		diags = append(diags, AsDiagnostics(err)...)
+
+		newObj.Sort()
+		upsertMember(parent, sectionKey, newObj)
+		if len(diags) > 0 {
+			return diags
+		}
+		return nil
+	}
+}
+
+// HandleValue only allows a child to set a section to the same value as the
+// parent. It's used for network-wide options such as randomizeClientPort,
+// which affect the whole tailnet, so a child can't set one the parent doesn't.
+func HandleValue() SectionHandler {
+	return func(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+
+		existing := parent.FindKey(ast.TextEqual(sectionKey))
+		if existing == nil {
+			return ctx.Errorf(childSection, RuleUnsupportedSection, "[%s] is not set in [%s], a child may only set it to the parent's value", sectionKey, ctx.ParentPath)
+		}
+
+		if existing.Value.JSON() != childSection.Value.JSON() {
+			return ctx.Errorf(childSection, RuleConflictingKey, "conflicting value for [%s], set to %s in [%s] and %s in [%s]", sectionKey, existing.Value.JSON(), ctx.ParentPath, childSection.Value.JSON(), ctx.ChildPath)
+		}
+
+		addMergeComment(existing, ctx.ParentPath, ctx.ChildPath)
+		addAuthorComments(existing, childSection)
+		return nil
+	}
+}
+
+func upsertMember[V *jwcc.Object | *jwcc.Array](doc *jwcc.Object, key string, val V) {
+	keyAst := ast.String(key)
+	index := doc.IndexKey(ast.TextEqual(key))
+	if index != -1 {
+		// keep the comments on the parent's section
+		doc.Members[index].Value = jwcc.Value(val)
+	} else {
+		doc.Members = append(doc.Members, &jwcc.Member{Key: keyAst.Quote(), Value: jwcc.Value(val)})
+	}
+}
+
+func existingOrNewArray(ctx *MergeContext, doc jwcc.Object, key string) (*jwcc.Array, error) { // TODO: combine with existingOrNewObject and pass in type?
+	existingSection := doc.FindKey(ast.TextEqual(key))
+	if existingSection == nil {
+		ctx.Logf("section [%s] not found in parent doc, creating new array\n", key)
+		return new(jwcc.Array), nil
+	}
+	arr, ok := existingSection.Value.(*jwcc.Array)
+	if !ok {
+		return nil, diagnosticAt(ctx.ParentPath, existingSection, RuleInvalidType, "section [%s] must be an array, got %s", key, valueKind(existingSection.Value))
+	}
+	ctx.Logf("section [%s] found in parent doc, re-using array\n", key)
+	return arr, nil
+}
+
+func existingOrNewObject(ctx *MergeContext, doc jwcc.Object, key string) (*jwcc.Object, error) {
+	existingSection := doc.FindKey(ast.TextEqual(key))
+	if existingSection == nil {
+		ctx.Logf("section [%s] not found in parent doc, creating new object\n", key)
+		return new(jwcc.Object), nil
+	}
+	obj, ok := existingSection.Value.(*jwcc.Object)
+	if !ok {
+		return nil, diagnosticAt(ctx.ParentPath, existingSection, RuleInvalidType, "section [%s] must be an object, got %s", key, valueKind(existingSection.Value))
+	}
+	ctx.Logf("section [%s] found in parent doc, re-using object\n", key)
+	return obj, nil
+}
@@ -0,0 +1,748 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestExistingOrNewObject(t *testing.T) {
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+	}
+
+	goodpathObject, err := existingOrNewObject(&MergeContext{}, *childDoc.Object, "goodpath")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(goodpathObject.Members) != 1 {
+		t.Fatalf("object members length should be [1], got [%v]", len(goodpathObject.Members))
+	}
+
+	badpathObject, err := existingOrNewObject(&MergeContext{}, *childDoc.Object, "badpath")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(badpathObject.Members) != 0 {
+		t.Fatalf("object members length should be [0], got [%v]", len(badpathObject.Members))
+	}
+}
+
+func TestExistingOrNewArray(t *testing.T) {
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": ["bar"]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+	}
+
+	goodpathObject, err := existingOrNewArray(&MergeContext{}, *childDoc.Object, "goodpath")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(goodpathObject.Values) != 1 {
+		t.Fatalf("object members length should be [1], got [%v]", len(goodpathObject.Values))
+	}
+
+	badpathObject, err := existingOrNewArray(&MergeContext{}, *childDoc.Object, "badpath")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(badpathObject.Values) != 0 {
+		t.Fatalf("object members length should be [0], got [%v]", len(badpathObject.Values))
+	}
+}
+
+func TestRegistryAllow(t *testing.T) {
+	actualValue := HandleObject(ConflictUnion)
+	defined := Registry{
+		"1": actualValue,
+		"2": actualValue,
+		"3": actualValue,
+	}
+	allowed := []string{"1", "2"}
+	allowedAclSections, err := defined.Allow(allowed)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	// should exist
+	section1 := allowedAclSections["1"]
+	if section1 == nil {
+		t.Fatalf("section [%v] should NOT be [nil]", "1")
+	}
+	section2 := allowedAclSections["2"]
+	if section2 == nil {
+		t.Fatalf("section [%v] should NOT be [nil]", "2")
+	}
+
+	// should not exist
+	section3 := allowedAclSections["3"]
+	if section3 != nil {
+		t.Fatalf("section [%v] SHOULD be [nil]", "3")
+	}
+	sectionZ := allowedAclSections["Z"]
+	if sectionZ != nil {
+		t.Fatalf("section [%v] SHOULD be [nil]", "Z")
+	}
+}
+
+func TestRegistryAllowInvalidSection(t *testing.T) {
+	actualValue := HandleObject(ConflictUnion)
+	defined := Registry{
+		"1": actualValue,
+		"2": actualValue,
+		"3": actualValue,
+	}
+	allowed := []string{"1", "2", "invalid"}
+	_, err := defined.Allow(allowed)
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+}
+
+func TestHandleArray(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			{"action": "accept", "src": ["finance1"], "dst": ["tag:demo-infra:22"]},
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("acls")
+
+	handlerFn := HandleArray()
+	handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "CHILD"}, "acls", parentDoc.Object, childSection)
+
+	mergedValues := parentDoc.Object.Find("acls").Value.(*jwcc.Array).Values
+	if len(mergedValues) != 2 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "acls", len(mergedValues))
+	}
+}
+
+func TestHandleObject(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:from_child": [
+				"dave@example.com",
+				"laura@example.com",
+			],
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("groups")
+
+	handlerFn := HandleObject(ConflictUnion)
+	handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "CHILD"}, "groups", parentDoc.Object, childSection)
+
+	mergedValues := parentDoc.Object.Find("groups").Value.(*jwcc.Object).Members
+	if len(mergedValues) != 3 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "groups", len(mergedValues))
+	}
+}
+
+func TestHandleAutoApprovers(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"autoApprovers": {
+			"routes": {
+				"10.0.1.0/24": ["group:engineering", "alice@example.com", "tag:foo"],
+			},
+			"exitNode": ["tag:foo"],
+			"services": {
+				"svc:web-server": ["tag:server"],
+				"tag:prod-service": ["tag:prod-infra"],
+			}
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("autoApprovers")
+
+	handlerFn := HandleAutoApprovers(ConflictUnion)
+	handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "CHILD"}, "autoApprovers", parentDoc.Object, childSection)
+
+	mergedValues := parentDoc.Object.Find("autoApprovers").Value
+	if len(mergedValues.(*jwcc.Object).Members) != 3 {
+		t.Fatalf("section [%v] should be [3], not [%v]", "autoApprovers", len(mergedValues.(*jwcc.Object).Members))
+	}
+
+	routesValues := mergedValues.(*jwcc.Object).Find("routes").Value.(*jwcc.Object).Members
+	if len(routesValues) != 2 {
+		t.Fatalf("section [%v] should be [2], not [%v]", "routes", len(routesValues))
+	}
+
+	exitNodeValues := mergedValues.(*jwcc.Object).Find("exitNode").Value.(*jwcc.Array).Values
+	if len(exitNodeValues) != 2 {
+		t.Fatalf("section [%v] should be [2], not [%v]", "exitNode", len(exitNodeValues))
+	}
+
+	servicesValues := mergedValues.(*jwcc.Object).Find("services").Value.(*jwcc.Object).Members
+	if len(servicesValues) != 2 {
+		t.Fatalf("section [%v] should be [2], not [%v]", "services", len(servicesValues))
+	}
+}
+
+func TestHandleObjectMergesGroupsWithSameName(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:group-name": [
+				"member-1@company.com",
+				"member-2@company.com",
+			]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "companies/company-1/groups.hujson",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:group-name": [
+				"member-3@company.com",
+				"member-4@company.com",
+			]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("groups")
+
+	handlerFn := HandleObject(ConflictUnion)
+	handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "companies/company-2/groups.hujson"}, "groups", parentDoc.Object, childSection)
+
+	groupsSection := parentDoc.Object.Find("groups").Value.(*jwcc.Object)
+	if len(groupsSection.Members) != 1 {
+		t.Fatalf("expected 1 group, got [%v]", len(groupsSection.Members))
+	}
+
+	groupMembers := groupsSection.Members[0].Value.(*jwcc.Array).Values
+	if len(groupMembers) != 4 {
+		t.Fatalf("expected 4 members in merged group, got [%v]", len(groupMembers))
+	}
+
+	expectedMembers := map[string]bool{
+		"member-1@company.com": false,
+		"member-2@company.com": false,
+		"member-3@company.com": false,
+		"member-4@company.com": false,
+	}
+	for _, m := range groupMembers {
+		memberStr := m.String()
+		if _, exists := expectedMembers[memberStr]; exists {
+			expectedMembers[memberStr] = true
+		}
+	}
+	for member, found := range expectedMembers {
+		if !found {
+			t.Fatalf("expected member [%v] not found in merged group", member)
+		}
+	}
+}
+
+func TestHandleObjectMergesGroupsWithDeduplication(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:shared": [
+				"user-a@example.com",
+				"user-b@example.com",
+			]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:shared": [
+				"user-b@example.com",
+				"user-c@example.com",
+			]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("groups")
+
+	handlerFn := HandleObject(ConflictUnion)
+	handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "child"}, "groups", parentDoc.Object, childSection)
+
+	groupsSection := parentDoc.Object.Find("groups").Value.(*jwcc.Object)
+
+	if len(groupsSection.Members) != 1 {
+		t.Fatalf("expected 1 group, got [%v]", len(groupsSection.Members))
+	}
+
+	groupMembers := groupsSection.Members[0].Value.(*jwcc.Array).Values
+	if len(groupMembers) != 3 {
+		t.Fatalf("expected 3 members (deduplicated), got [%v]", len(groupMembers))
+	}
+}
+
+func TestHandleObjectMergesMultipleGroupsFromMultipleChildren(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child1, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:shared": ["user-a@example.com"]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	child2, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:shared": ["user-b@example.com"]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	child3, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:shared": ["user-c@example.com"]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	handlerFn := HandleObject(ConflictUnion)
+
+	childSection1 := child1.Value.(*jwcc.Object).Find("groups")
+	handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "child1"}, "groups", parentDoc.Object, childSection1)
+
+	childSection2 := child2.Value.(*jwcc.Object).Find("groups")
+	handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "child2"}, "groups", parentDoc.Object, childSection2)
+
+	childSection3 := child3.Value.(*jwcc.Object).Find("groups")
+	handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "child3"}, "groups", parentDoc.Object, childSection3)
+
+	groupsSection := parentDoc.Object.Find("groups").Value.(*jwcc.Object)
+
+	if len(groupsSection.Members) != 1 {
+		t.Fatalf("expected 1 group, got [%v]", len(groupsSection.Members))
+	}
+
+	groupMembers := groupsSection.Members[0].Value.(*jwcc.Array).Values
+	if len(groupMembers) != 3 {
+		t.Fatalf("expected 3 members from 3 children, got [%v]", len(groupMembers))
+	}
+}
+
+func TestHandleObjectPreservesDistinctGroups(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:engineering": ["eng-1@example.com"],
+			"group:sales": ["sales-1@example.com"]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:engineering": ["eng-2@example.com"],
+			"group:finance": ["finance-1@example.com"]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("groups")
+
+	handlerFn := HandleObject(ConflictUnion)
+	handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "child"}, "groups", parentDoc.Object, childSection)
+
+	groupsSection := parentDoc.Object.Find("groups").Value.(*jwcc.Object)
+
+	if len(groupsSection.Members) != 3 {
+		t.Fatalf("expected 3 groups, got [%v]", len(groupsSection.Members))
+	}
+
+	engGroup := groupsSection.FindKey(ast.TextEqual("group:engineering"))
+	if engGroup == nil {
+		t.Fatalf("expected engineering group to exist")
+	}
+	engMembers := engGroup.Value.(*jwcc.Array).Values
+	if len(engMembers) != 2 {
+		t.Fatalf("expected 2 members in engineering group, got [%v]", len(engMembers))
+	}
+
+	salesGroup := groupsSection.FindKey(ast.TextEqual("group:sales"))
+	if salesGroup == nil {
+		t.Fatalf("expected sales group to exist")
+	}
+	salesMembers := salesGroup.Value.(*jwcc.Array).Values
+	if len(salesMembers) != 1 {
+		t.Fatalf("expected 1 member in sales group, got [%v]", len(salesMembers))
+	}
+
+	// Finance should have 1 member (new)
+	financeGroup := groupsSection.FindKey(ast.TextEqual("group:finance"))
+	if financeGroup == nil {
+		t.Fatalf("expected finance group to exist")
+	}
+	financeMembers := financeGroup.Value.(*jwcc.Array).Values
+	if len(financeMembers) != 1 {
+		t.Fatalf("expected 1 member in finance group, got [%v]", len(financeMembers))
+	}
+}
+
+func TestMergeArraysWithDedup(t *testing.T) {
+	arr1, err := jwcc.Parse(strings.NewReader(`["a", "b", "c"]`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	arr2, err := jwcc.Parse(strings.NewReader(`["b", "c", "d"]`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	result := mergeArraysWithDedup(arr1.Value.(*jwcc.Array), arr2.Value.(*jwcc.Array))
+
+	if len(result.Values) != 4 {
+		t.Fatalf("expected 4 values, got [%v]", len(result.Values))
+	}
+
+	expected := map[string]bool{"a": false, "b": false, "c": false, "d": false}
+	for _, v := range result.Values {
+		if _, exists := expected[v.String()]; exists {
+			expected[v.String()] = true
+		}
+	}
+	for val, found := range expected {
+		if !found {
+			t.Fatalf("expected value [%v] not found", val)
+		}
+	}
+}
+
+func TestMergeArraysWithDedupEmptyArrays(t *testing.T) {
+	arr1, err := jwcc.Parse(strings.NewReader(`[]`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	arr2, err := jwcc.Parse(strings.NewReader(`["a"]`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	result := mergeArraysWithDedup(arr1.Value.(*jwcc.Array), arr2.Value.(*jwcc.Array))
+	if len(result.Values) != 1 {
+		t.Fatalf("expected 1 value, got [%v]", len(result.Values))
+	}
+
+	result2 := mergeArraysWithDedup(arr2.Value.(*jwcc.Array), arr1.Value.(*jwcc.Array))
+	if len(result2.Values) != 1 {
+		t.Fatalf("expected 1 value, got [%v]", len(result2.Values))
+	}
+}
+
+func mergeHostsWithStrategy(t *testing.T, strategy ConflictStrategy) (*ParsedDocument, error) {
+	t.Helper()
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host1": "100.99.98.97",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child1, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host1": "100.1.1.1",
+			"host2": "100.2.2.2",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	child2, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host2": "100.3.3.3",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	sections := Registry{
+		"hosts": HandleObject(strategy),
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{
+		{Object: child1.Value.(*jwcc.Object), Path: "child1"},
+		{Object: child2.Value.(*jwcc.Object), Path: "child2"},
+	}, Options{Sections: sections})
+	return parentDoc, err
+}
+
+func hostValue(doc *ParsedDocument, host string) string {
+	return doc.Object.Find("hosts").Value.(*jwcc.Object).FindKey(ast.TextEqual(host)).Value.String()
+}
+
+func TestConflictStrategyError(t *testing.T) {
+	_, err := mergeHostsWithStrategy(t, ConflictError)
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+	if !strings.Contains(err.Error(), "host1") || !strings.Contains(err.Error(), "[parent] and [child1]") {
+		t.Fatalf("expected error to name the key and both files, got [%v]", err)
+	}
+}
+
+func TestConflictStrategyUnion(t *testing.T) {
+	_, err := mergeHostsWithStrategy(t, ConflictUnion)
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+	if !strings.Contains(err.Error(), "cannot merge key") {
+		t.Fatalf("expected merge error, got [%v]", err)
+	}
+}
+
+func TestConflictStrategyUnionSameValue(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host1": "100.99.98.97",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host1": "100.99.98.97",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	handlerFn := HandleObject(ConflictUnion)
+	err = handlerFn(&MergeContext{ParentPath: parentDoc.Path, ChildPath: "child"}, "hosts", parentDoc.Object, child.Value.(*jwcc.Object).Find("hosts"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	hosts := parentDoc.Object.Find("hosts").Value.(*jwcc.Object)
+	if len(hosts.Members) != 1 {
+		t.Fatalf("expected 1 host, got [%v]", len(hosts.Members))
+	}
+	sources := sourcesFromComments(hosts.Members[0].Comments().Before)
+	if len(sources) != 2 || sources[0] != "parent" || sources[1] != "child" {
+		t.Fatalf("expected sources [parent child], got [%v]", sources)
+	}
+}
+
+func TestConflictStrategyParentWins(t *testing.T) {
+	parentDoc, err := mergeHostsWithStrategy(t, ConflictParentWins)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if v := hostValue(parentDoc, "host1"); v != "100.99.98.97" {
+		t.Fatalf("expected host1 from parent, got [%v]", v)
+	}
+	if v := hostValue(parentDoc, "host2"); v != "100.2.2.2" {
+		t.Fatalf("expected host2 from child1, got [%v]", v)
+	}
+}
+
+func TestConflictStrategyFirstChildWins(t *testing.T) {
+	parentDoc, err := mergeHostsWithStrategy(t, ConflictFirstChildWins)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if v := hostValue(parentDoc, "host1"); v != "100.1.1.1" {
+		t.Fatalf("expected host1 from child1, got [%v]", v)
+	}
+	if v := hostValue(parentDoc, "host2"); v != "100.2.2.2" {
+		t.Fatalf("expected host2 from child1, got [%v]", v)
+	}
+}
+
+func TestRegistrySetConflictStrategy(t *testing.T) {
+	registry := DefaultRegistry()
+
+	err := registry.SetConflictStrategy("hosts", ConflictError)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	err = registry.SetConflictStrategy("acls", ConflictError)
+	if err == nil {
+		t.Fatalf("expected error for array section, got [%v]", err)
+	}
+
+	err = registry.SetConflictStrategy("invalid", ConflictError)
+	if err == nil {
+		t.Fatalf("expected error for unsupported section, got [%v]", err)
+	}
+}
+
+func TestParseConflictStrategy(t *testing.T) {
+	strategy, err := ParseConflictStrategy("first-child-wins")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if strategy != ConflictFirstChildWins {
+		t.Fatalf("strategy should be [%v], got [%v]", ConflictFirstChildWins, strategy)
+	}
+
+	_, err = ParseConflictStrategy("last-wins")
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+}
+
+func TestHandleValue(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		// acl-combiner: from ` + "`parent`" + `
+		"randomizeClientPort": true,
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentObj := parent.Value.(*jwcc.Object)
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"randomizeClientPort": true,
+		"OneCGNATRoute": "mullvad",
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childObj := child.Value.(*jwcc.Object)
+
+	handlerFn := HandleValue()
+	ctx := &MergeContext{ParentPath: "parent", ChildPath: "child1"}
+	err = handlerFn(ctx, "randomizeClientPort", parentObj, childObj.Find("randomizeClientPort"))
+	if err != nil {
+		t.Fatalf("expected no error for the same value, got [%v]", err)
+	}
+	if sources := sourcesFromComments(parentObj.Find("randomizeClientPort").Comments().Before); len(sources) != 2 || sources[1] != "child1" {
+		t.Fatalf("expected child to be added to the provenance, got [%v]", sources)
+	}
+
+	err = handlerFn(ctx, "OneCGNATRoute", parentObj, childObj.Find("OneCGNATRoute"))
+	expected := "child1:3:3: [OneCGNATRoute] is not set in [parent], a child may only set it to the parent's value"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected [%s], got [%v]", expected, err)
+	}
+	if v := parentObj.Find("OneCGNATRoute"); v != nil {
+		t.Fatalf("expected the parent to be unchanged, got [%v]", v)
+	}
+
+	conflicting, err := jwcc.Parse(strings.NewReader(`{
+		"randomizeClientPort": false,
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	conflictingObj := conflicting.Value.(*jwcc.Object)
+
+	ctx = &MergeContext{ParentPath: "parent", ChildPath: "child2"}
+	err = handlerFn(ctx, "randomizeClientPort", parentObj, conflictingObj.Find("randomizeClientPort"))
+	expected = "child2:2:3: conflicting value for [randomizeClientPort], set to true in [parent] and false in [child2]"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected [%s], got [%v]", expected, err)
+	}
+}
+
+func TestRegistryAllowCaseInsensitive(t *testing.T) {
+	allowed, err := DefaultRegistry().Allow([]string{"ACLs", "RandomizeClientPort", "onecgnatroute"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	names := allowed.Names()
+	if len(names) != 3 || names[0] != "OneCGNATRoute" || names[1] != "acls" || names[2] != "randomizeClientPort" {
+		t.Fatalf("expected canonical section names, got [%v]", names)
+	}
+}
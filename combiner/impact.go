@@ -0,0 +1,210 @@
+package combiner
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// An Access is a source that can reach a destination on ports, allowed by
+// acls or grants. Groups and ipsets are expanded, so Src and Dst are users,
+// tags, autogroups, hosts, addresses or "*".
+type Access struct {
+	Src   string
+	Dst   string
+	Ports string // e.g. "22", "8000-8999" or "*"
+	Proto string // empty if the rule applies to TCP, UDP and ICMP
+	// Sources are the files of the rules allowing the access.
+	Sources []string
+}
+
+func (a Access) String() string {
+	s := fmt.Sprintf("%s -> %s:%s", a.Src, a.Dst, a.Ports)
+	if a.Proto != "" {
+		s += fmt.Sprintf(" over [%s]", a.Proto)
+	}
+	return s
+}
+
+func (a Access) key() string {
+	return strings.Join([]string{a.Src, a.Dst, a.Ports, a.Proto}, "\x00")
+}
+
+// An AccessImpact is the access gained and lost between two policies.
+type AccessImpact struct {
+	Gained []Access
+	Lost   []Access
+}
+
+// PolicyAccess returns every access allowed by the acls and grants of doc,
+// sorted by source, destination and ports. Postures and via aren't taken into
+// account, and grants only allowing app capabilities don't allow any access.
+func PolicyAccess(doc *jwcc.Object) ([]Access, Diagnostics) {
+	e, diags := newPolicyEvaluator(doc)
+
+	found := map[string]*Access{}
+	add := func(src string, dst string, ports string, proto string, sources []string) {
+		a := Access{Src: src, Dst: dst, Ports: ports, Proto: strings.ToLower(proto)}
+		existing, ok := found[a.key()]
+		if !ok {
+			existing = &a
+			found[a.key()] = existing
+		}
+		for _, s := range sources {
+			if !slices.Contains(existing.Sources, s) {
+				existing.Sources = append(existing.Sources, s)
+			}
+		}
+	}
+
+	for _, r := range e.acls {
+		if r.Value.Action != "accept" {
+			continue
+		}
+		for _, src := range e.expandAll(r.Value.Src) {
+			for _, dst := range r.Value.Dst {
+				host, ports, ok := splitHostPorts(dst)
+				if !ok {
+					continue
+				}
+				for _, dstHost := range e.expand(host, nil) {
+					for _, p := range strings.Split(ports, ",") {
+						add(src, dstHost, p, r.Value.Proto, r.Sources)
+					}
+				}
+			}
+		}
+	}
+
+	for _, g := range e.grants {
+		for _, src := range e.expandAll(g.Value.Src) {
+			for _, dst := range e.expandAll(g.Value.Dst) {
+				for _, ip := range g.Value.IP {
+					proto, ports := splitIPCapability(ip)
+					for _, p := range strings.Split(ports, ",") {
+						add(src, dst, p, proto, g.Sources)
+					}
+				}
+			}
+		}
+	}
+
+	access := make([]Access, 0, len(found))
+	for _, a := range found {
+		access = append(access, *a)
+	}
+	sort.Slice(access, func(i, j int) bool {
+		a, b := access[i], access[j]
+		if a.Src != b.Src {
+			return a.Src < b.Src
+		}
+		if a.Dst != b.Dst {
+			return a.Dst < b.Dst
+		}
+		if a.Ports != b.Ports {
+			return a.Ports < b.Ports
+		}
+		return a.Proto < b.Proto
+	})
+	return access, diags
+}
+
+// DiffAccess returns the access allowed by newDoc but not oldDoc, and the
+// access allowed by oldDoc but not newDoc. Access is compared as written in
+// the rules, so changing "80,443" to "80-443" loses and gains access even
+// though port 80 is still allowed.
+func DiffAccess(oldDoc *jwcc.Object, newDoc *jwcc.Object) (AccessImpact, Diagnostics) {
+	oldAccess, diags := PolicyAccess(oldDoc)
+	newAccess, newDiags := PolicyAccess(newDoc)
+	diags = append(diags, newDiags...)
+
+	impact := AccessImpact{Gained: []Access{}, Lost: []Access{}}
+	oldKeys := map[string]bool{}
+	for _, a := range oldAccess {
+		oldKeys[a.key()] = true
+	}
+	newKeys := map[string]bool{}
+	for _, a := range newAccess {
+		newKeys[a.key()] = true
+		if !oldKeys[a.key()] {
+			impact.Gained = append(impact.Gained, a)
+		}
+	}
+	for _, a := range oldAccess {
+		if !newKeys[a.key()] {
+			impact.Lost = append(impact.Lost, a)
+		}
+	}
+	return impact, diags
+}
+
+func (e *policyEvaluator) expandAll(selectors []string) []string {
+	expanded := []string{}
+	for _, sel := range selectors {
+		for _, s := range e.expand(sel, nil) {
+			if !slices.Contains(expanded, s) {
+				expanded = append(expanded, s)
+			}
+		}
+	}
+	return expanded
+}
+
+// expand returns the members of groups and the entries of ipsets in sel,
+// recursively, or sel itself if it's neither. Entries an ipset removes are
+// only left out if they're written the same way as the entry they remove.
+func (e *policyEvaluator) expand(sel string, seen map[string]bool) []string {
+	if seen == nil {
+		seen = map[string]bool{}
+	}
+	if seen[sel] {
+		return nil
+	}
+	seen[sel] = true
+
+	switch {
+	case strings.HasPrefix(sel, "group:"):
+		expanded := []string{}
+		for _, member := range e.policy.Groups[sel] {
+			expanded = append(expanded, e.expand(member, seen)...)
+		}
+		return expanded
+
+	case strings.HasPrefix(sel, "ipset:"):
+		removed := []string{}
+		for _, entry := range e.policy.IPSets[sel] {
+			if r, ok := strings.CutPrefix(entry, "remove "); ok {
+				removed = append(removed, e.expand(strings.TrimSpace(r), seen)...)
+			}
+		}
+		expanded := []string{}
+		for _, entry := range e.policy.IPSets[sel] {
+			if strings.HasPrefix(entry, "remove ") {
+				continue
+			}
+			for _, s := range e.expand(strings.TrimSpace(strings.TrimPrefix(entry, "add ")), seen) {
+				if !slices.Contains(removed, s) {
+					expanded = append(expanded, s)
+				}
+			}
+		}
+		return expanded
+	}
+	return []string{sel}
+}
+
+// splitIPCapability splits an entry of a grant's ip, such as "*", "443",
+// "tcp:80-90" or "icmp", into its protocol and ports.
+func splitIPCapability(ip string) (string, string) {
+	proto, ports, ok := strings.Cut(ip, ":")
+	if ok {
+		return proto, ports
+	}
+	if ip != "*" && ip != "" && (ip[0] < '0' || ip[0] > '9') {
+		return ip, "*"
+	}
+	return "", ip
+}
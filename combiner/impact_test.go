@@ -0,0 +1,80 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+)
+
+const IMPACT_OLD = `{
+	"groups": {
+		"group:finance": ["finance1@example.com"],
+		"group:eng":     ["engineering3@example.com", "group:eng-leads"],
+		"group:eng-leads": ["lead@example.com"],
+	},
+	"ipsets": {
+		"ipset:office": ["192.0.2.0/24", "198.51.100.0/24", "remove 198.51.100.0/24"],
+	},
+	"acls": [
+		// acl-combiner: from ` + "`finance.hujson`" + `
+		{"action": "accept", "src": ["group:finance"], "dst": ["tag:demo-infra:22,80"]},
+		// acl-combiner: from ` + "`eng.hujson`" + `
+		{"action": "accept", "src": ["group:eng"], "dst": ["tag:dev:443"]},
+	],
+	"grants": [
+		// acl-combiner: from ` + "`office.hujson`" + `
+		{"src": ["ipset:office"], "dst": ["tag:printer"], "ip": ["tcp:631", "icmp"]},
+		{"src": ["group:eng"], "dst": ["tag:k8s"], "app": {"tailscale.com/cap/kubernetes": [{}]}},
+	],
+}`
+
+func TestPolicyAccess(t *testing.T) {
+	access, diags := PolicyAccess(parseDiffDoc(t, IMPACT_OLD))
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got [%v]", diags)
+	}
+
+	expected := []string{
+		"192.0.2.0/24 -> tag:printer:* over [icmp] from [office.hujson]",
+		"192.0.2.0/24 -> tag:printer:631 over [tcp] from [office.hujson]",
+		"engineering3@example.com -> tag:dev:443 from [eng.hujson]",
+		"finance1@example.com -> tag:demo-infra:22 from [finance.hujson]",
+		"finance1@example.com -> tag:demo-infra:80 from [finance.hujson]",
+		"lead@example.com -> tag:dev:443 from [eng.hujson]",
+	}
+	if len(access) != len(expected) {
+		t.Fatalf("expected [%d] access, got [%v]", len(expected), access)
+	}
+	for i, a := range access {
+		if s := a.String() + " from [" + strings.Join(a.Sources, ", ") + "]"; s != expected[i] {
+			t.Errorf("access [%d] should be [%s], got [%s]", i, expected[i], s)
+		}
+	}
+}
+
+func TestDiffAccess(t *testing.T) {
+	newDoc := parseDiffDoc(t, strings.NewReplacer(
+		`"group:finance": ["finance1@example.com"]`, `"group:finance": ["finance1@example.com", "finance2@example.com"]`,
+		`"group:eng":     ["engineering3@example.com", "group:eng-leads"]`, `"group:eng": ["group:eng-leads"]`,
+	).Replace(IMPACT_OLD))
+
+	impact, diags := DiffAccess(parseDiffDoc(t, IMPACT_OLD), newDoc)
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got [%v]", diags)
+	}
+
+	gained := []string{
+		"finance2@example.com -> tag:demo-infra:22",
+		"finance2@example.com -> tag:demo-infra:80",
+	}
+	if len(impact.Gained) != len(gained) {
+		t.Fatalf("expected [%d] gained, got [%v]", len(gained), impact.Gained)
+	}
+	for i, a := range impact.Gained {
+		if a.String() != gained[i] {
+			t.Errorf("gained [%d] should be [%s], got [%s]", i, gained[i], a)
+		}
+	}
+	if len(impact.Lost) != 1 || impact.Lost[0].String() != "engineering3@example.com -> tag:dev:443" {
+		t.Fatalf("expected engineering3@example.com to lose access to tag:dev:443, got [%v]", impact.Lost)
+	}
+}
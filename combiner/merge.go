@@ -0,0 +1,499 @@
+package combiner
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// A PathRule applies to child files under paths matching Pattern. Sections,
+// Tags, Groups and Prefixes are separate controls: for each of them, a child
+// uses the most specific matching rule that sets it, so a rule for a
+// subdirectory can allow different sections without dropping the tag
+// namespace of a rule for its parent directory.
+type PathRule struct {
+	// Pattern is a path.Match pattern, matched against the child file's path
+	// relative to the directory it was gathered from, with and without that
+	// directory's name, and each of their parent directories.
+	Pattern string
+
+	// Sections are the sections allowed from matching files. If nil, the
+	// rule doesn't set sections.
+	Sections Registry
+
+	// Tags are path.Match patterns, e.g. "tag:finance*", for the tags matching
+	// files may own and reference. If empty, the rule doesn't limit tags.
+	Tags []string
+
+	// Groups are path.Match patterns, e.g. "group:finance*", for the groups
+	// only matching files may define or extend. Once any rule has groups,
+	// children may only define or extend groups owned by a rule matching them.
+	Groups []string
+
+	// Prefixes are the address blocks allocated to matching files. If not
+	// empty, their autoApprovers routes and ipsets must be inside them.
+	Prefixes []netip.Prefix
+}
+
+func (r PathRule) String() string {
+	if r.Sections == nil {
+		return r.Pattern
+	}
+	return fmt.Sprintf("%s=%s", r.Pattern, strings.Join(r.Sections.Names(), ","))
+}
+
+// Matches reports whether the pattern matches filePath or any of its parent directories.
+func (r PathRule) Matches(filePath string) bool {
+	p := filepath.ToSlash(filepath.Clean(filePath))
+	for {
+		if ok, _ := path.Match(r.Pattern, p); ok {
+			return true
+		}
+		dir := path.Dir(p)
+		if dir == p || dir == "." {
+			return false
+		}
+		p = dir
+	}
+}
+
+// matchesAny reports whether the pattern matches any of paths.
+func (r PathRule) matchesAny(paths []string) bool {
+	return slices.ContainsFunc(paths, r.Matches)
+}
+
+// specificity returns the number of path components in the rule's pattern, so
+// a rule for a subdirectory is more specific than a rule for its parent.
+func (r PathRule) specificity() int {
+	return strings.Count(path.Clean(r.Pattern), "/") + 1
+}
+
+// mostSpecificRule returns the most specific rule matching one of a child's
+// rulePaths for which sets is true, or the later rule if two are equally
+// specific. It returns nil if no such rule matches.
+func mostSpecificRule(rules []PathRule, childPaths []string, sets func(r PathRule) bool) *PathRule {
+	var found *PathRule
+	for i := range rules {
+		if !sets(rules[i]) || !rules[i].matchesAny(childPaths) {
+			continue
+		}
+		if found == nil || rules[i].specificity() >= found.specificity() {
+			found = &rules[i]
+		}
+	}
+	return found
+}
+
+// A MergeContext describes the child file being merged by a SectionHandler.
+type MergeContext struct {
+	ParentPath string
+	ChildPath  string
+
+	// Rule is the most specific rule matching the child that sets sections,
+	// or nil if the default sections apply.
+	Rule *PathRule
+
+	rules      []PathRule
+	childPaths []string     // the paths rules are matched against, see rulePaths
+	child      *jwcc.Object // the child's sections, before any are merged
+	logf       func(format string, args ...any)
+}
+
+// Errorf returns an error Diagnostic from rule for the position of v in the child file.
+func (c *MergeContext) Errorf(v jwcc.Value, rule string, format string, args ...any) error {
+	return diagnosticAt(c.ChildPath, v, rule, format, args...)
+}
+
+// Logf writes a verbose progress message.
+func (c *MergeContext) Logf(format string, args ...any) {
+	if c.logf != nil {
+		c.logf(format, args...)
+	}
+}
+
+// ruleFor returns the most specific rule matching the child for which sets is
+// true, or nil if there is none.
+func (c *MergeContext) ruleFor(sets func(r PathRule) bool) *PathRule {
+	return mostSpecificRule(c.rules, c.childPaths, sets)
+}
+
+// sectionsForChild returns the most specific rule matching a child file that
+// sets sections, and the sections it allows, or the default sections if there
+// is no such rule.
+func sectionsForChild(opts Options, childPaths []string) (Registry, *PathRule) {
+	rule := mostSpecificRule(opts.Rules, childPaths, func(r PathRule) bool { return r.Sections != nil })
+	if rule == nil {
+		return opts.Sections, nil
+	}
+	return rule.Sections, rule
+}
+
+// Merge merges the allowed sections of each child into parent. Every child
+// is merged even if an earlier one has problems, and all problems are
+// returned together as Diagnostics. They may only be warnings, which
+// Diagnostics.HasErrors tells apart.
+func Merge(parentDoc *ParsedDocument, childDocs []*ParsedDocument, opts Options) error {
+	names := sectionNames(opts)
+	normalizeSectionKeys(parentDoc, names, opts)
+	addParentPathComments(parentDoc, opts)
+
+	diags := Diagnostics{}
+	for _, child := range childDocs {
+		if child.Path == parentDoc.Path {
+			opts.logf("skipping [%s], same doc as parent\n", child.Path)
+			continue
+		}
+
+		normalizeSectionKeys(child, names, opts)
+		childPaths := child.rulePaths()
+		sections, rule := sectionsForChild(opts, childPaths)
+		if rule != nil {
+			opts.logf("using rule [%s] for [%s]\n", rule, child.Path)
+		}
+
+		ctx := &MergeContext{
+			ParentPath: parentDoc.Path,
+			ChildPath:  child.Path,
+			Rule:       rule,
+			rules:      opts.Rules,
+			childPaths: childPaths,
+			child:      &jwcc.Object{Members: slices.Clone(child.Object.Members)},
+			logf:       opts.Logf,
+		}
+
+		for sectionKey, handlerFn := range sections {
+			childSection := child.Object.Find(sectionKey)
+			if childSection == nil {
+				continue
+			}
+
+			err := guardHandler(handlerFn)(ctx, sectionKey, parentDoc.Object, childSection)
+			if err != nil {
+				diags = append(diags, sectionDiagnostics(ctx, childSection, err)...)
+			}
+			child.Object.Members = removeMember(child.Object, sectionKey)
+		}
+
+		for _, remainingSection := range child.Object.Members {
+			if rule != nil {
+				diags = append(diags, diagnosticAt(child.Path, remainingSection, RuleUnsupportedSection, "unsupported section [\"%s\"], not allowed by rule [%s]", remainingSection.Key, rule))
+				continue
+			}
+			diags = append(diags, diagnosticAt(child.Path, remainingSection, RuleUnsupportedSection, "unsupported section [\"%s\"]", remainingSection.Key))
+		}
+	}
+
+	for _, section := range parentDoc.Object.Members {
+		switch v := section.Value.(type) {
+		case *jwcc.Object:
+			sortMembersBySource(v)
+			dedupeCommentsInObject(v)
+		case *jwcc.Array:
+			dedupeCommentsInArray(v)
+		}
+	}
+
+	parentDoc.Object.Sort()
+
+	if len(diags) > 0 {
+		diags.Sort()
+		return diags
+	}
+	return nil
+}
+
+// sectionDiagnostics returns the diagnostics in an error from a SectionHandler,
+// placing errors without a path at the child's section.
+func sectionDiagnostics(ctx *MergeContext, childSection *jwcc.Member, err error) Diagnostics {
+	diags := AsDiagnostics(err)
+	for i, d := range diags {
+		if d.Path == "" {
+			diags[i] = diagnosticAt(ctx.ChildPath, childSection, RuleSectionHandler, "%s", d.Message)
+		}
+	}
+	return diags
+}
+
+// sectionNames returns the names of the default sections and of the sections
+// in opts, which may include custom sections.
+func sectionNames(opts Options) []string {
+	names := DefaultRegistry().Names()
+	names = append(names, opts.Sections.Names()...)
+	for _, r := range opts.Rules {
+		names = append(names, r.Sections.Names()...)
+	}
+	return names
+}
+
+// normalizeSectionKeys renames the sections of doc matching one of names
+// case-insensitively, such as "RandomizeClientPort", to that spelling.
+func normalizeSectionKeys(doc *ParsedDocument, names []string, opts Options) {
+	for _, m := range doc.Object.Members {
+		key := m.Key.String()
+		if name := canonicalSectionName(key, names); name != key {
+			opts.logf("renaming section [%s] in [%s] to [%s]\n", key, doc.Path, name)
+			m.Key = ast.String(name).Quote()
+		}
+	}
+}
+
+// canonicalSectionName returns the first of names matching key
+// case-insensitively, or key if none do.
+func canonicalSectionName(key string, names []string) string {
+	for _, name := range names {
+		if strings.EqualFold(key, name) {
+			return name
+		}
+	}
+	return key
+}
+
+func addParentPathComments(parentDoc *ParsedDocument, opts Options) {
+	for _, parentSection := range parentDoc.Object.Members {
+		opts.logf("adding parent path comment to [%s]\n", parentSection.Key)
+		switch parentSection.Value.(type) {
+		default:
+			pathComment(parentSection, parentDoc.Path)
+		case *jwcc.Array:
+			for _, val := range parentSection.Value.(*jwcc.Array).Values {
+				pathComment(val, parentDoc.Path)
+			}
+		case *jwcc.Object:
+			for _, member := range parentSection.Value.(*jwcc.Object).Members {
+				pathComment(member, parentDoc.Path)
+			}
+		}
+	}
+}
+
+// provenancePrefix starts every provenance comment, so a comment the author
+// wrote, such as "from `legacy`", isn't mistaken for one.
+const provenancePrefix = "acl-combiner: "
+
+// fromComment and andComment return the provenance comments for the first
+// file a value came from, and each file merged into it after that.
+func fromComment(path string) string { return fmt.Sprintf("%sfrom `%s`", provenancePrefix, path) }
+func andComment(path string) string  { return fmt.Sprintf("%sand `%s`", provenancePrefix, path) }
+
+// pathComment records that val came from the file at path, replacing any
+// provenance comments it has. The author's comments are kept after it.
+func pathComment(val jwcc.Value, path string) {
+	_, author := splitComments(val.Comments().Before)
+	val.Comments().Before = append([]string{fromComment(path)}, author...)
+}
+
+// addMergeComment records that childPath was merged into val, after the
+// provenance comments val already has.
+func addMergeComment(val jwcc.Value, parentPath string, childPath string) {
+	provenance, author := splitComments(val.Comments().Before)
+
+	if len(provenance) == 0 {
+		provenance = []string{fromComment(parentPath)}
+	}
+
+	newComment := andComment(childPath)
+	for _, c := range provenance {
+		if c == newComment || c == fromComment(childPath) {
+			return
+		}
+	}
+
+	val.Comments().Before = append(append(provenance, newComment), author...)
+}
+
+// addAuthorComments adds the author's comments on src, a value merged into
+// dst, to the comments on dst. A value has a single line comment, so if both
+// have one, src's is kept with the comments before dst.
+func addAuthorComments(dst jwcc.Value, src jwcc.Value) {
+	_, author := splitComments(src.Comments().Before)
+	if line := src.Comments().Line; line != "" && line != dst.Comments().Line {
+		if dst.Comments().Line == "" {
+			dst.Comments().Line = line
+		} else {
+			author = append(author, line)
+		}
+	}
+	for _, c := range author {
+		if c != "" && !slices.Contains(dst.Comments().Before, c) {
+			dst.Comments().Before = append(dst.Comments().Before, c)
+		}
+	}
+	for _, c := range src.Comments().End {
+		if !slices.Contains(dst.Comments().End, c) {
+			dst.Comments().End = append(dst.Comments().End, c)
+		}
+	}
+}
+
+// copyMember returns a new member with the key, value and comments of m.
+func copyMember(m *jwcc.Member) *jwcc.Member {
+	newMember := &jwcc.Member{Key: m.Key, Value: m.Value}
+	*newMember.Comments() = *m.Comments()
+	return newMember
+}
+
+// isProvenanceComment reports whether c was added by pathComment or
+// addMergeComment, rather than written by the author of the file.
+func isProvenanceComment(c string) bool {
+	return len(sourcesFromComments([]string{c})) > 0
+}
+
+// splitComments returns the provenance comments and the author's comments.
+func splitComments(comments []string) ([]string, []string) {
+	provenance := []string{}
+	author := []string{}
+	for _, c := range comments {
+		if isProvenanceComment(c) {
+			provenance = append(provenance, c)
+		} else {
+			author = append(author, c)
+		}
+	}
+	return provenance, author
+}
+
+// sourcesFromComments returns the file paths recorded by pathComment and
+// addMergeComment, in the order they were added.
+func sourcesFromComments(comments []string) []string {
+	sources := []string{}
+	for _, c := range comments {
+		c, ok := strings.CutPrefix(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(c), "//")), provenancePrefix)
+		if !ok {
+			continue
+		}
+		for _, prefix := range []string{"from `", "and `"} {
+			if strings.HasPrefix(c, prefix) && strings.HasSuffix(c, "`") {
+				sources = append(sources, strings.TrimSuffix(strings.TrimPrefix(c, prefix), "`"))
+			}
+		}
+	}
+	return sources
+}
+
+// inheritSources returns the provenance of v, or sources if v has none.
+// Provenance comments are deduped, so a value without one came from the same
+// files as the sibling before it.
+func inheritSources(v jwcc.Value, sources []string) []string {
+	if vSources := sourcesFromComments(v.Comments().Before); len(vSources) > 0 {
+		return vSources
+	}
+	return sources
+}
+
+// A policyEntry is an item of an array section, with the files it came from.
+type policyEntry struct {
+	Index   int
+	Value   jwcc.Value
+	Sources []string
+}
+
+// sectionEntries returns the items of the array section named key in doc.
+func sectionEntries(doc *jwcc.Object, key string) []policyEntry {
+	section := doc.Find(key)
+	if section == nil {
+		return nil
+	}
+	arr, ok := section.Value.(*jwcc.Array)
+	if !ok {
+		return nil
+	}
+
+	entries := []policyEntry{}
+	sources := sourcesFromComments(section.Comments().Before)
+	for i, v := range arr.Values {
+		sources = inheritSources(v, sources)
+		entries = append(entries, policyEntry{Index: i, Value: v, Sources: sources})
+	}
+	return entries
+}
+
+// errorf returns an error Diagnostic from rule for the position of the entry in
+// the file it came from.
+func (e policyEntry) errorf(rule string, format string, args ...any) Diagnostic {
+	return diagnosticAt(sourcePath(e.Sources), e.Value, rule, format, args...)
+}
+
+func sortMembersBySource(obj *jwcc.Object) {
+	sort.SliceStable(obj.Members, func(i, j int) bool {
+		provenanceI, _ := splitComments(obj.Members[i].Comments().Before)
+		provenanceJ, _ := splitComments(obj.Members[j].Comments().Before)
+		return strings.Join(provenanceI, "\n") < strings.Join(provenanceJ, "\n")
+	})
+}
+
+// dedupeProvenance removes the provenance comments from c if they're the same
+// as last, the provenance of the value before it. The author's comments are
+// kept. It returns the provenance of the value, which is last if c has none.
+func dedupeProvenance(c *jwcc.Comments, last []string) []string {
+	provenance, author := splitComments(c.Before)
+	if len(provenance) == 0 {
+		return last
+	}
+	if slices.Equal(last, provenance) {
+		c.Before = author
+	}
+	return provenance
+}
+
+func dedupeCommentsInObject(obj *jwcc.Object) {
+	var lastProvenance []string
+	for _, member := range obj.Members {
+		lastProvenance = dedupeProvenance(member.Comments(), lastProvenance)
+
+		switch v := member.Value.(type) {
+		case *jwcc.Array:
+			dedupeCommentsInArray(v)
+		case *jwcc.Object:
+			dedupeCommentsInObject(v)
+		}
+	}
+}
+
+func dedupeCommentsInArray(arr *jwcc.Array) {
+	var lastProvenance []string
+	for _, val := range arr.Values {
+		lastProvenance = dedupeProvenance(val.Comments(), lastProvenance)
+
+		switch v := val.(type) {
+		case *jwcc.Object:
+			dedupeCommentsInObject(v)
+		case *jwcc.Array:
+			dedupeCommentsInArray(v)
+		}
+	}
+}
+
+func removeMember(obj *jwcc.Object, key string) []*jwcc.Member {
+	indexKey := obj.IndexKey(ast.TextEqual(key))
+
+	if indexKey == -1 {
+		return obj.Members
+	}
+
+	ret := make([]*jwcc.Member, 0)
+	ret = append(ret, obj.Members[:indexKey]...)
+	return append(ret, obj.Members[indexKey+1:]...)
+}
+
+// UnmatchedRules returns a warning for each rule whose pattern matches none of
+// children, which is usually a mistyped path or a path relative to the wrong
+// directory.
+func UnmatchedRules(rules []PathRule, children []*ParsedDocument) Diagnostics {
+	diags := Diagnostics{}
+	for _, rule := range rules {
+		matched := slices.ContainsFunc(children, func(child *ParsedDocument) bool {
+			return rule.matchesAny(child.rulePaths())
+		})
+		if !matched {
+			diags = append(diags, Diagnostic{Severity: SeverityWarning, Rule: RuleUnmatchedPath, Message: fmt.Sprintf("rule for path [%s] matches no child file", rule.Pattern)})
+		}
+	}
+	return diags
+}
@@ -0,0 +1,895 @@
+package combiner
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const (
+	ACL_PARENT = `{
+	"groups": {
+		"group:engineering": [
+			"dave@example.com",
+			"laura@example.com",
+		],
+		"group:sales": [
+			"brad@example.com",
+			"alice@example.com",
+		],
+	},
+	"acls": [
+		{
+			"action": "accept",
+			"src": ["group:security-team@example.com"],
+			"dst": ["tag:logging:*"]
+		}
+	],
+	"tagOwners": {
+		"tag:logging": ["group:security-team@example.com"]
+	},
+	"autoApprovers": {
+		"routes": {
+			"192.0.2.0/24": ["group:engineering", "alice@example.com", "tag:foo"],
+		},
+		"exitNode": ["tag:bar"],
+	},
+}`
+)
+
+func TestMergeDocsEmptyParent(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		// empty parent
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := Registry{
+		"goodpath": HandleObject(ConflictUnion),
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: sections})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 1 {
+		t.Fatalf("parent members length should be [1], got [%v]", len(parentDoc.Object.Members))
+	}
+
+	if parentDoc.Object.IndexKey(ast.TextEqual("goodpath")) != 0 {
+		t.Fatalf("section index key length should be [0], got [%v]", parentDoc.Object.IndexKey(ast.TextEqual("goodpath")))
+	}
+}
+
+func TestMergeDocsParentWithDifferentMembers(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := Registry{
+		"goodpath": HandleObject(ConflictUnion),
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: sections})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 5 {
+		t.Fatalf("parent members length should be [5], got [%v]", len(parentDoc.Object.Members))
+	}
+}
+
+func TestMergeDocsParentWithSameMember(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"bar":"foo"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := Registry{
+		"goodpath": HandleObject(ConflictUnion),
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: sections})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 1 {
+		t.Fatalf("parent members length should be [1], got [%v]", len(parentDoc.Object.Members))
+	}
+
+	memberIndexKey := parentDoc.Object.IndexKey(ast.TextEqual("goodpath"))
+	if memberIndexKey != 0 {
+		t.Fatalf("section index key length should be [0], got [%v]", memberIndexKey)
+	}
+
+	member := parentDoc.Object.Members[memberIndexKey]
+	memberObjectMembers := member.Value.(*jwcc.Object).Members
+	if len(memberObjectMembers) != 2 {
+		t.Fatalf("member object keys length should be [2], got [%v]", len(memberObjectMembers))
+	}
+}
+
+func TestPathCommentsForObject(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"bar":"foo"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := Registry{
+		"goodpath": HandleObject(ConflictUnion),
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: sections})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 1 {
+		t.Fatalf("parent members length should be [1], got [%v]", len(parentDoc.Object.Members))
+	}
+
+	memberIndexKey := parentDoc.Object.IndexKey(ast.TextEqual("goodpath"))
+	if memberIndexKey != 0 {
+		t.Fatalf("section index key length should be [0], got [%v]", memberIndexKey)
+	}
+
+	member := parentDoc.Object.Members[memberIndexKey]
+	memberObjectMembers := member.Value.(*jwcc.Object).Members
+	if len(memberObjectMembers) != 2 {
+		t.Fatalf("member object keys length should be [2], got [%v]", len(memberObjectMembers))
+	}
+
+	barMember := member.Value.(*jwcc.Object).Find("bar")
+	if barMember.Value.String() != "foo" {
+		t.Fatalf("member value should be [foo], got [%v]", barMember.Value.String())
+	}
+	barMemberComments := barMember.Comments().Before
+	if barMemberComments[0] != "acl-combiner: from `parent`" {
+		t.Fatalf("member comment should be [acl-combiner: from `parent`], got [%v]", barMemberComments[0])
+	}
+
+	fooMember := member.Value.(*jwcc.Object).Find("foo")
+	if fooMember.Value.String() != "bar" {
+		t.Fatalf("member value should be [bar], got [%v]", fooMember.Value.String())
+	}
+	fooMemberComments := fooMember.Comments().Before
+	if fooMemberComments[0] != "acl-combiner: from `child`" {
+		t.Fatalf("member comment should be [acl-combiner: from `child`], got [%v]", fooMemberComments[0])
+	}
+}
+
+func TestPathCommentsForArray(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"things": [{"thing1":"foo"}],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"things": [{"thing2":"bar"}],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := Registry{
+		"things": HandleArray(),
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: sections})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 1 {
+		t.Fatalf("parent members length should be [1], got [%v]", len(parentDoc.Object.Members))
+	}
+
+	thingsMember := parentDoc.Object.Find("things")
+	if thingsMember == nil {
+		t.Fatalf("section index key length should be not nil, got [%v]", thingsMember)
+	}
+
+	thingsMemberValues := thingsMember.Value.(*jwcc.Array).Values
+	if len(thingsMemberValues) != 2 {
+		t.Fatalf("members length should be [2], got [%v]", len(thingsMemberValues))
+	}
+
+	barMember := thingsMemberValues[0].(*jwcc.Object)
+	if barMember.Members[0].Key.String() != "thing1" {
+		t.Fatalf("member key should be [thing1], got [%v]", barMember.Members[0].Key.String())
+	}
+	if barMember.Members[0].Value.String() != "foo" {
+		t.Fatalf("member value should be [foo], got [%v]", barMember.Members[0].Value.String())
+	}
+	if barMember.Comments().Before[0] != "acl-combiner: from `parent`" {
+		t.Fatalf("member comment should be [acl-combiner: from `parent`], got [%v]", barMember.Comments().Before[0])
+	}
+
+	fooMember := thingsMemberValues[1].(*jwcc.Object)
+	if fooMember.Members[0].Key.String() != "thing2" {
+		t.Fatalf("member key should be [thing2], got [%v]", fooMember.Members[0].Key.String())
+	}
+	if fooMember.Members[0].Value.String() != "bar" {
+		t.Fatalf("member value should be [bar], got [%v]", fooMember.Members[0].Value.String())
+	}
+	if fooMember.Comments().Before[0] != "acl-combiner: from `child`" {
+		t.Fatalf("member comment should be [acl-combiner: from `parent`], got [%v]", fooMember.Comments().Before[0])
+	}
+}
+
+func TestPathCommentsKeepAuthorComments(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		// owners of each team
+		"groups": {
+			// parent team
+			"group:a": ["alice@example.com"],
+		},
+		"things": [
+			// first thing
+			{"thing": 1},
+		],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			// child team
+			"group:a": ["bob@example.com"],
+			"group:b": ["carol@example.com"], // new team
+		},
+		"things": [
+			// same comment
+			{"thing": 2},
+			// same comment
+			{"thing": 3},
+		],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{Object: child.Value.(*jwcc.Object), Path: "child"}
+
+	sections := Registry{
+		"groups": HandleObject(ConflictUnion),
+		"things": HandleArray(),
+	}
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: sections})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	out, err := Format(parentDoc.Object)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	expected := `{
+	// owners of each team
+	"groups": {
+		// acl-combiner: from ` + "`child`" + `
+		"group:b": ["carol@example.com"], // new team
+
+		// acl-combiner: from ` + "`parent`" + `
+		// acl-combiner: and ` + "`child`" + `
+		// parent team
+		// child team
+		"group:a": ["alice@example.com", "bob@example.com"],
+	},
+
+	"things": [
+		// acl-combiner: from ` + "`parent`" + `
+		// first thing
+		{
+			"thing": 1,
+		},
+		// acl-combiner: from ` + "`child`" + `
+		// same comment
+		{
+			"thing": 2,
+		},
+		// same comment
+		{
+			"thing": 3,
+		},
+	],
+}
+`
+	if string(out) != expected {
+		t.Fatalf("expected output [%s], got [%s]", expected, out)
+	}
+}
+
+func TestPathCommentsKeepAuthorProvenanceText(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			// from ` + "`legacy`" + `
+			"group:a": ["alice@example.com"], // parent line
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:a": ["bob@example.com"], // child line
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{Object: child.Value.(*jwcc.Object), Path: "child"}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: Registry{"groups": HandleObject(ConflictUnion)}})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	out, err := Format(parentDoc.Object)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	expected := `{
+	"groups": {
+		// acl-combiner: from ` + "`parent`" + `
+		// acl-combiner: and ` + "`child`" + `
+		// from ` + "`legacy`" + `
+		// child line
+		"group:a": ["alice@example.com", "bob@example.com"], // parent line
+	},
+}
+`
+	if string(out) != expected {
+		t.Fatalf("expected output [%s], got [%s]", expected, out)
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"bar":"foo"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+	}
+
+	sameMembers := removeMember(parentDoc.Object, "NOTHING_TO_REMOVE")
+	if len(sameMembers) != 1 {
+		t.Fatalf("members count should be [%v], got [%v]", 1, len(sameMembers))
+	}
+
+	removedMembers := removeMember(parentDoc.Object, "goodpath")
+	if len(removedMembers) != 0 {
+		t.Fatalf("members count should be [%v], got [%v]", 0, len(removedMembers))
+	}
+}
+
+func TestEmptyParentObject(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{"hosts":{}}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host1": "100.99.98.97",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: DefaultRegistry()})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	mergedValues := parentDoc.Object.Find("hosts").Value.(*jwcc.Object).Members
+	if len(mergedValues) != 1 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "hosts", len(mergedValues))
+	}
+}
+
+func TestEmptyParentArray(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{"acls":[]}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			{"action": "accept", "src": ["finance1"], "dst": ["tag:demo-infra:22"]},
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: DefaultRegistry()})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	mergedValues := parentDoc.Object.Find("acls").Value.(*jwcc.Array).Values
+	if len(mergedValues) != 1 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "acls", len(mergedValues))
+	}
+}
+
+func TestEmptyChildObject(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host1": "100.99.98.97",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{"hosts":{}}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: DefaultRegistry()})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	mergedValues := parentDoc.Object.Find("hosts").Value.(*jwcc.Object).Members
+	if len(mergedValues) != 1 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "hosts", len(mergedValues))
+	}
+}
+
+func TestEmptyChildArray(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			{"action": "accept", "src": ["finance1"], "dst": ["tag:demo-infra:22"]},
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{"acls":[]}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: DefaultRegistry()})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	mergedValues := parentDoc.Object.Find("acls").Value.(*jwcc.Array).Values
+	if len(mergedValues) != 1 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "acls", len(mergedValues))
+	}
+}
+
+func TestSort(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"autoApprovers": {
+			"routes": {
+				"10.0.1.0/24": ["group:engineering", "alice@example.com", "tag:foo"],
+			},
+			"exitNode": ["tag:foo"],
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: DefaultRegistry()})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	expectedSort := []string{"acls", "autoApprovers", "groups", "tagOwners"}
+	for i, v := range expectedSort {
+		if parentDoc.Object.Members[i].Key.String() != v {
+			t.Fatalf("section [%v] should be position [%v]", v, i)
+		}
+	}
+}
+
+func printDocument(doc *ParsedDocument) {
+	err := jwcc.Format(os.Stdout, doc.Object)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestPathRuleMatches(t *testing.T) {
+	rule := PathRule{Pattern: "departments/finance"}
+	if !rule.Matches("departments/finance/acls.hujson") {
+		t.Fatalf("rule [%v] should match file in directory", rule)
+	}
+	if !rule.Matches("./departments/finance/nested/acls.hujson") {
+		t.Fatalf("rule [%v] should match file in nested directory", rule)
+	}
+	if rule.Matches("departments/finance-other/acls.hujson") {
+		t.Fatalf("rule [%v] should NOT match sibling directory", rule)
+	}
+
+	globRule := PathRule{Pattern: "departments/*"}
+	if !globRule.Matches("departments/engineering/acls.hujson") {
+		t.Fatalf("rule [%v] should match file in directory", globRule)
+	}
+	if globRule.Matches("platform/acls.hujson") {
+		t.Fatalf("rule [%v] should NOT match other directory", globRule)
+	}
+}
+
+func TestPathRulesRelativeToRoot(t *testing.T) {
+	children, err := GatherChildren("../testdata/departments", Options{})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	rules := []PathRule{
+		{Pattern: "departments/finance", Tags: []string{"tag:finance*"}},
+		{Pattern: "engineering", Tags: []string{"tag:engineering*"}},
+		{Pattern: "testdata/departments", Tags: []string{"tag:*"}},
+		{Pattern: "platform", Tags: []string{"tag:platform*"}},
+	}
+	for _, child := range children {
+		rule := mostSpecificRule(rules, child.rulePaths(), func(r PathRule) bool { return len(r.Tags) > 0 })
+		expected := "engineering"
+		if strings.Contains(child.Path, "finance") {
+			expected = "departments/finance"
+		}
+		if rule == nil || rule.Pattern != expected {
+			t.Errorf("expected [%s] to match rule [%s], got [%v]", child.Path, expected, rule)
+		}
+	}
+
+	diags := UnmatchedRules(rules, children)
+	expected := []string{
+		"rule for path [testdata/departments] matches no child file",
+		"rule for path [platform] matches no child file",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), diags)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] || d.Severity != SeverityWarning {
+			t.Errorf("diagnostic [%d] should be a warning [%s], got [%s] [%s]", i, expected[i], d.Severity, d)
+		}
+	}
+}
+
+func TestMergeDocsWithAllowRules(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	finance, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			{"action": "accept", "src": ["finance1"], "dst": ["tag:demo-infra:22"]},
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	platform, err := jwcc.Parse(strings.NewReader(`{
+		"tagOwners": {
+			"tag:platform": ["group:engineering"],
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	registry := DefaultRegistry()
+	sections, err := registry.Allow([]string{"acls"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	financeSections, err := registry.Allow([]string{"acls", "tests"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	platformSections, err := registry.Allow([]string{"acls", "tagOwners", "autoApprovers"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{
+		{Object: finance.Value.(*jwcc.Object), Path: "departments/finance/acls.hujson"},
+		{Object: platform.Value.(*jwcc.Object), Path: "platform/tags.hujson"},
+	}, Options{
+		Sections: sections,
+		Rules: []PathRule{
+			{Pattern: "departments/finance", Sections: financeSections},
+			{Pattern: "platform", Sections: platformSections},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	tagOwners := parentDoc.Object.Find("tagOwners").Value.(*jwcc.Object).Members
+	if len(tagOwners) != 2 {
+		t.Fatalf("section [%v] should be [2], not [%v]", "tagOwners", len(tagOwners))
+	}
+}
+
+func TestMergeDocsWithAllowRulesDenied(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"tagOwners": {
+			"tag:finance": ["group:finance"],
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	registry := DefaultRegistry()
+	sections, err := registry.Allow([]string{"acls", "tagOwners"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	financeSections, err := registry.Allow([]string{"acls", "tests"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{
+		{Object: child.Value.(*jwcc.Object), Path: "departments/finance/tags.hujson"},
+	}, Options{
+		Sections: sections,
+		Rules:    []PathRule{{Pattern: "departments/finance", Sections: financeSections}},
+	})
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+	if !strings.Contains(err.Error(), "rule [departments/finance=acls,tests]") {
+		t.Fatalf("expected error to name the rule, got [%v]", err)
+	}
+}
+
+func TestMergeReportsAllErrors(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child1, err := jwcc.Parse(strings.NewReader(`{
+		"acls": {},
+		"foo": [],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	child2, err := jwcc.Parse(strings.NewReader(`{
+		"groups": [],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	sections, err := DefaultRegistry().Allow([]string{"acls", "groups"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{
+		{Object: child2.Value.(*jwcc.Object), Path: "child2"},
+		{Object: child1.Value.(*jwcc.Object), Path: "child1"},
+	}, Options{Sections: sections})
+
+	diags := AsDiagnostics(err)
+	expected := []string{
+		"child1:2:3: section [acls] must be an array, got object",
+		"child1:3:3: unsupported section [\"foo\"]",
+		"child2:2:3: section [groups] must be an object, got array",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), err)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] {
+			t.Fatalf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+}
+
+func TestMergeNormalizesSectionKeys(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"RandomizeClientPort": true,
+		"ACLs": [],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"randomizeclientport": true,
+		"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	sections, err := DefaultRegistry().Allow([]string{"acls", "randomizeClientPort"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{{Object: child.Value.(*jwcc.Object), Path: "child"}}, Options{Sections: sections})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	keys := []string{}
+	for _, m := range parentDoc.Object.Members {
+		keys = append(keys, m.Key.String())
+	}
+	if len(keys) != 2 || keys[0] != "acls" || keys[1] != "randomizeClientPort" {
+		t.Fatalf("expected canonical section names, got [%v]", keys)
+	}
+	if acls := parentDoc.Object.Find("acls").Value.(*jwcc.Array); len(acls.Values) != 1 {
+		t.Fatalf("expected child acls to be merged, got [%v]", acls.Values)
+	}
+}
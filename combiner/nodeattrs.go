@@ -0,0 +1,304 @@
+package combiner
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// appConnectorsCapability is the app capability defining app connectors -
+// https://tailscale.com/kb/1281/app-connectors
+const appConnectorsCapability = "tailscale.com/app-connectors"
+
+// exclusiveAttrs are attr prefixes a target can only have one value for, and
+// the values with the prefix that are options rather than a choice, e.g. a
+// target can only use one NextDNS profile, but may add "nextdns:no-device-info".
+var exclusiveAttrs = map[string][]string{
+	"nextdns:": {"no-device-info"},
+}
+
+// HandleNodeAttrs merges each entry of a child's nodeAttrs into the parent's
+// entry for the same targets, if there is one. The attrs of both are combined,
+// and app connectors with the same name are combined, merging their domains,
+// connectors and routes. Attrs a target can only have one of, such as NextDNS
+// profiles, and differing values for any other field are conflicts.
+func HandleNodeAttrs() SectionHandler {
+	return func(ctx *MergeContext, sectionKey string, parent *jwcc.Object, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+
+		childArr, ok := childSection.Value.(*jwcc.Array)
+		if !ok {
+			return ctx.Errorf(childSection, RuleInvalidType, "section [%s] must be an array, got %s", sectionKey, valueKind(childSection.Value))
+		}
+
+		newArr, err := existingOrNewArray(ctx, *parent, sectionKey)
+		if err != nil {
+			return err
+		}
+
+		diags := Diagnostics{}
+		for _, v := range childArr.Values {
+			existing := findNodeAttr(newArr, v)
+			if existing == nil {
+				pathComment(v, ctx.ChildPath)
+				newArr.Values = append(newArr.Values, v)
+				continue
+			}
+
+			entryDiags := mergeNodeAttr(ctx, existing, v.(*jwcc.Object))
+			if len(entryDiags) > 0 {
+				diags = append(diags, entryDiags...)
+				continue
+			}
+			addMergeComment(existing, ctx.ParentPath, ctx.ChildPath)
+			addAuthorComments(existing, v)
+		}
+
+		upsertMember(parent, sectionKey, newArr)
+		if len(diags) > 0 {
+			return diags
+		}
+		return nil
+	}
+}
+
+// nodeAttrTarget returns the targets of a nodeAttrs entry, sorted and without
+// duplicates, so that entries for the same targets can be found.
+func nodeAttrTarget(v jwcc.Value) (string, bool) {
+	obj, ok := v.(*jwcc.Object)
+	if !ok {
+		return "", false
+	}
+	target := obj.Find("target")
+	if target == nil {
+		return "", false
+	}
+	targets := []string{}
+	for _, d := range stringValues(target.Value) {
+		targets = append(targets, d.Value.(ast.Text).String())
+	}
+	if len(targets) == 0 {
+		return "", false
+	}
+	slices.Sort(targets)
+	return strings.Join(slices.Compact(targets), ","), true
+}
+
+// findNodeAttr returns the entry of nodeAttrs with the same targets as v.
+func findNodeAttr(nodeAttrs *jwcc.Array, v jwcc.Value) *jwcc.Object {
+	target, ok := nodeAttrTarget(v)
+	if !ok {
+		return nil
+	}
+	for _, existing := range nodeAttrs.Values {
+		if existingTarget, ok := nodeAttrTarget(existing); ok && existingTarget == target {
+			return existing.(*jwcc.Object)
+		}
+	}
+	return nil
+}
+
+// mergeNodeAttr merges the child's entry into existing, unless they conflict.
+func mergeNodeAttr(ctx *MergeContext, existing *jwcc.Object, child *jwcc.Object) Diagnostics {
+	target, _ := nodeAttrTarget(existing)
+	existingSource := ctx.ParentPath
+	if sources := sourcesFromComments(existing.Comments().Before); len(sources) > 0 {
+		existingSource = strings.Join(sources, ", ")
+	}
+
+	diags := Diagnostics{}
+	if attr := child.Find("attr"); attr != nil {
+		if existingAttr := existing.Find("attr"); existingAttr != nil {
+			for _, d := range stringValues(attr.Value) {
+				if conflict := conflictingAttr(existingAttr.Value, d.Value.(ast.Text).String()); conflict != "" {
+					diags = append(diags, diagnosticAt(ctx.ChildPath, d, RuleConflictingKey, "conflicting attr [%s] for target [%s] in nodeAttrs, [%s] is set in [%s]", d.Value.(ast.Text).String(), target, conflict, existingSource))
+				}
+			}
+		}
+	}
+	if app := child.Find("app"); app != nil {
+		if existingApp := existing.Find("app"); existingApp != nil {
+			diags = append(diags, checkNodeAttrApp(ctx, target, existingSource, existingApp, app)...)
+		}
+	}
+	for _, m := range child.Members {
+		key := m.Key.String()
+		if key == "target" || key == "attr" || key == "app" {
+			continue
+		}
+		if existingField := existing.Find(key); existingField != nil && existingField.Value.JSON() != m.Value.JSON() {
+			diags = append(diags, diagnosticAt(ctx.ChildPath, m, RuleConflictingKey, "conflicting value for [%s] for target [%s] in nodeAttrs, defined in [%s] and [%s]", key, target, existingSource, ctx.ChildPath))
+		}
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+
+	for _, m := range child.Members {
+		existingField := existing.Find(m.Key.String())
+		switch {
+		case existingField == nil:
+			existing.Members = append(existing.Members, copyMember(m))
+		case m.Key.String() == "attr":
+			existingField.Value = mergeArrays(existingField.Value, m.Value)
+		case m.Key.String() == "app":
+			mergeNodeAttrApp(existingField.Value.(*jwcc.Object), m.Value.(*jwcc.Object))
+		}
+	}
+	return nil
+}
+
+// conflictingAttr returns the attr in existing that attr conflicts with, or ""
+// if there isn't one.
+func conflictingAttr(existing jwcc.Value, attr string) string {
+	for prefix, options := range exclusiveAttrs {
+		value, ok := strings.CutPrefix(attr, prefix)
+		if !ok || slices.Contains(options, value) {
+			continue
+		}
+		for _, d := range stringValues(existing) {
+			existingAttr := d.Value.(ast.Text).String()
+			existingValue, ok := strings.CutPrefix(existingAttr, prefix)
+			if ok && existingValue != value && !slices.Contains(options, existingValue) {
+				return existingAttr
+			}
+		}
+	}
+	return ""
+}
+
+// checkNodeAttrApp returns the conflicts between the app capabilities of two
+// entries for the same targets.
+func checkNodeAttrApp(ctx *MergeContext, target string, existingSource string, existingApp *jwcc.Member, app *jwcc.Member) Diagnostics {
+	existingObj, ok := existingApp.Value.(*jwcc.Object)
+	if !ok {
+		return Diagnostics{diagnosticAt(existingSource, existingApp, RuleInvalidType, "app for target [%s] in nodeAttrs must be an object, got %s", target, valueKind(existingApp.Value))}
+	}
+	obj, ok := app.Value.(*jwcc.Object)
+	if !ok {
+		return Diagnostics{diagnosticAt(ctx.ChildPath, app, RuleInvalidType, "app for target [%s] in nodeAttrs must be an object, got %s", target, valueKind(app.Value))}
+	}
+
+	diags := Diagnostics{}
+	for _, capability := range obj.Members {
+		existingCapability := existingObj.FindKey(ast.TextEqual(capability.Key.String()))
+		if existingCapability == nil {
+			continue
+		}
+		existingArr, existingIsArr := existingCapability.Value.(*jwcc.Array)
+		arr, isArr := capability.Value.(*jwcc.Array)
+		if !existingIsArr || !isArr {
+			if existingCapability.Value.JSON() != capability.Value.JSON() {
+				diags = append(diags, diagnosticAt(ctx.ChildPath, capability, RuleConflictingKey, "conflicting value for app [%s] for target [%s] in nodeAttrs, defined in [%s] and [%s]", capability.Key.String(), target, existingSource, ctx.ChildPath))
+			}
+			continue
+		}
+		if capability.Key.String() != appConnectorsCapability {
+			continue
+		}
+
+		for _, connector := range arr.Values {
+			existingConnector := findAppConnector(existingArr, appConnectorName(connector))
+			if existingConnector == nil {
+				continue
+			}
+			for _, m := range connector.(*jwcc.Object).Members {
+				if isAppConnectorList(m.Key.String()) {
+					continue
+				}
+				if existingField := existingConnector.Find(m.Key.String()); existingField != nil && existingField.Value.JSON() != m.Value.JSON() {
+					diags = append(diags, diagnosticAt(ctx.ChildPath, m, RuleConflictingKey, "conflicting value for [%s] of app connector [%s] for target [%s] in nodeAttrs, defined in [%s] and [%s]", m.Key.String(), appConnectorName(connector), target, existingSource, ctx.ChildPath))
+				}
+			}
+		}
+	}
+	return diags
+}
+
+// mergeNodeAttrApp merges the app capabilities of a child's entry into
+// existing, combining app connectors by name and other capabilities' values.
+func mergeNodeAttrApp(existing *jwcc.Object, app *jwcc.Object) {
+	for _, capability := range app.Members {
+		existingCapability := existing.FindKey(ast.TextEqual(capability.Key.String()))
+		if existingCapability == nil {
+			existing.Members = append(existing.Members, copyMember(capability))
+			continue
+		}
+		existingArr, existingIsArr := existingCapability.Value.(*jwcc.Array)
+		arr, isArr := capability.Value.(*jwcc.Array)
+		if !existingIsArr || !isArr {
+			continue
+		}
+		if capability.Key.String() != appConnectorsCapability {
+			existingCapability.Value = mergeArrays(existingArr, arr)
+			continue
+		}
+
+		for _, connector := range arr.Values {
+			existingConnector := findAppConnector(existingArr, appConnectorName(connector))
+			if existingConnector == nil {
+				existingArr.Values = append(existingArr.Values, connector)
+				continue
+			}
+			for _, m := range connector.(*jwcc.Object).Members {
+				existingField := existingConnector.Find(m.Key.String())
+				switch {
+				case existingField == nil:
+					existingConnector.Members = append(existingConnector.Members, copyMember(m))
+				case isAppConnectorList(m.Key.String()):
+					existingField.Value = mergeArrays(existingField.Value, m.Value)
+				}
+			}
+		}
+	}
+}
+
+// isAppConnectorList reports whether key is a field of an app connector whose
+// values are combined when merging connectors with the same name.
+func isAppConnectorList(key string) bool {
+	return key == "domains" || key == "connectors" || key == "routes"
+}
+
+func appConnectorName(v jwcc.Value) string {
+	obj, ok := v.(*jwcc.Object)
+	if !ok {
+		return ""
+	}
+	name := obj.Find("name")
+	if name == nil {
+		return ""
+	}
+	names := stringValues(name.Value)
+	if len(names) != 1 {
+		return ""
+	}
+	return names[0].Value.(ast.Text).String()
+}
+
+// findAppConnector returns the app connector named name in connectors.
+func findAppConnector(connectors *jwcc.Array, name string) *jwcc.Object {
+	if name == "" {
+		return nil
+	}
+	for _, v := range connectors.Values {
+		if appConnectorName(v) == name {
+			return v.(*jwcc.Object)
+		}
+	}
+	return nil
+}
+
+// mergeArrays returns the values of existing and v combined without
+// duplicates, or existing if either isn't an array.
+func mergeArrays(existing jwcc.Value, v jwcc.Value) jwcc.Value {
+	existingArr, existingIsArr := existing.(*jwcc.Array)
+	arr, isArr := v.(*jwcc.Array)
+	if !existingIsArr || !isArr {
+		return existing
+	}
+	return mergeArraysWithDedup(existingArr, arr)
+}
@@ -0,0 +1,146 @@
+package combiner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const NODE_ATTRS_PARENT = `{
+	"nodeAttrs": [
+		{
+			"target": ["*"],
+			"app": {
+				"tailscale.com/app-connectors": [
+					{"name": "github", "connectors": ["tag:connector"], "domains": ["github.com"]},
+				],
+			},
+		},
+		{"target": ["user@example.com", "tag:server"], "attr": ["nextdns:abc123", "nextdns:no-device-info"]},
+	],
+}`
+
+func mergeNodeAttrsChildren(t *testing.T, children ...string) (*ParsedDocument, error) {
+	t.Helper()
+	parent, err := jwcc.Parse(strings.NewReader(NODE_ATTRS_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"}
+
+	childDocs := []*ParsedDocument{}
+	for i, child := range children {
+		doc, err := jwcc.Parse(strings.NewReader(child))
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		childDocs = append(childDocs, &ParsedDocument{Object: doc.Value.(*jwcc.Object), Path: fmt.Sprintf("child%d", i+1)})
+	}
+
+	sections, err := DefaultRegistry().Allow([]string{"nodeAttrs"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	return parentDoc, Merge(parentDoc, childDocs, Options{Sections: sections})
+}
+
+func TestHandleNodeAttrs(t *testing.T) {
+	parentDoc, err := mergeNodeAttrsChildren(t, `{
+		"nodeAttrs": [
+			{"target": ["tag:server", "user@example.com", "tag:server"], "attr": ["nextdns:abc123", "funnel"]},
+			{
+				"target": ["*"],
+				"app": {
+					"tailscale.com/app-connectors": [
+						{"name": "github", "connectors": ["tag:finance-connector"], "domains": ["*.github.com"]},
+						{"name": "salesforce", "connectors": ["tag:finance-connector"], "domains": ["salesforce.com"]},
+					],
+				},
+			},
+			{"target": ["autogroup:admin"], "attr": ["mullvad"]},
+		],
+	}`, `{
+		"nodeAttrs": [
+			{"target": ["autogroup:admin"], "attr": ["mullvad", "funnel"]},
+		],
+	}`)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	policy, diags := DecodePolicy(parentDoc.Object)
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got [%v]", diags)
+	}
+	if len(policy.NodeAttrs) != 3 {
+		t.Fatalf("expected entries merged by target, got [%+v]", policy.NodeAttrs)
+	}
+	if attr := strings.Join(policy.NodeAttrs[1].Attr, ","); attr != "nextdns:abc123,nextdns:no-device-info,funnel" {
+		t.Fatalf("expected attrs combined, got [%s]", attr)
+	}
+	if attr := strings.Join(policy.NodeAttrs[2].Attr, ","); attr != "mullvad,funnel" {
+		t.Fatalf("expected attrs combined from both children, got [%s]", attr)
+	}
+
+	connectors := policy.NodeAttrs[0].App[appConnectorsCapability]
+	expected := []string{
+		`{"name":"github","connectors":["tag:connector","tag:finance-connector"],"domains":["github.com","*.github.com"]}`,
+		`{"name":"salesforce","connectors":["tag:finance-connector"],"domains":["salesforce.com"]}`,
+	}
+	if len(connectors) != len(expected) {
+		t.Fatalf("expected [%d] app connectors, got [%s]", len(expected), connectors)
+	}
+	for i, connector := range connectors {
+		if string(connector) != expected[i] {
+			t.Errorf("app connector [%d] should be [%s], got [%s]", i, expected[i], connector)
+		}
+	}
+
+	entries := sectionEntries(parentDoc.Object, "nodeAttrs")
+	if sources := entries[1].Sources; len(sources) != 2 || sources[0] != "parent" || sources[1] != "child1" {
+		t.Fatalf("expected entry from parent and child, got [%v]", sources)
+	}
+	if sources := entries[2].Sources; len(sources) != 2 || sources[0] != "child1" || sources[1] != "child2" {
+		t.Fatalf("expected entry from both children, got [%v]", sources)
+	}
+}
+
+func TestHandleNodeAttrsConflicts(t *testing.T) {
+	_, err := mergeNodeAttrsChildren(t, `{
+		"nodeAttrs": [
+			{"target": ["tag:server", "user@example.com"], "attr": ["nextdns:def456", "nextdns:no-device-info"]},
+			{
+				"target": ["*"],
+				"app": {
+					"tailscale.com/app-connectors": [
+						{"name": "github", "connectors": ["tag:connector"], "domains": ["github.com"], "routes": ["192.0.2.0/24"]},
+					],
+				},
+			},
+		],
+	}`, `{
+		"nodeAttrs": [
+			{"target": ["*"], "ipPool": ["100.81.0.0/16"]},
+		],
+	}`, `{
+		"nodeAttrs": [
+			{"target": ["*"], "ipPool": ["100.82.0.0/16"]},
+		],
+	}`)
+
+	expected := []string{
+		"child1:3:60: conflicting attr [nextdns:def456] for target [tag:server,user@example.com] in nodeAttrs, [nextdns:abc123] is set in [parent]",
+		"child3:3:22: conflicting value for [ipPool] for target [*] in nodeAttrs, defined in [parent, child1, child2] and [child3]",
+	}
+	diags := AsDiagnostics(err)
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), err)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] {
+			t.Errorf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+}
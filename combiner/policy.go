@@ -0,0 +1,329 @@
+package combiner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// Policy is a typed model of a Tailscale policy file -
+// https://tailscale.com/kb/1337/acl-syntax. Merge works on the
+// comment-preserving jwcc tree, DecodePolicy decodes the result into a Policy
+// to validate it.
+type Policy struct {
+	ACLs              []ACLRule           `json:"acls"`
+	Grants            []Grant             `json:"grants"`
+	SSH               []SSHRule           `json:"ssh"`
+	Tests             []ACLTest           `json:"tests"`
+	SSHTests          []SSHTest           `json:"sshTests"`
+	NodeAttrs         []NodeAttr          `json:"nodeAttrs"`
+	AutoApprovers     AutoApprovers       `json:"autoApprovers"`
+	Groups            map[string][]string `json:"groups"`
+	Hosts             map[string]string   `json:"hosts"`
+	IPSets            map[string][]string `json:"ipsets"`
+	TagOwners         map[string][]string `json:"tagOwners"`
+	Postures          map[string][]string `json:"postures"`
+	DefaultSrcPosture []string            `json:"defaultSrcPosture"`
+	ExtraDNSRecords   []ExtraDNSRecord    `json:"extraDNSRecords"`
+
+	NetworkOptions
+}
+
+// NetworkOptions are the settings of a policy that apply to the whole
+// tailnet - https://tailscale.com/kb/1337/acl-syntax#network-policy-options
+type NetworkOptions struct {
+	DERPMap             *DERPMap `json:"derpMap"`
+	DisableIPv4         bool     `json:"disableIPv4"`
+	OneCGNATRoute       string   `json:"OneCGNATRoute"`
+	RandomizeClientPort bool     `json:"randomizeClientPort"`
+}
+
+type ACLRule struct {
+	Action     string   `json:"action"`
+	Proto      string   `json:"proto"`
+	Src        []string `json:"src"`
+	Dst        []string `json:"dst"`
+	SrcPosture []string `json:"srcPosture"`
+
+	// Users and Ports are the legacy names of Src and Dst, and are added to
+	// them when decoding.
+	Users []string `json:"users"`
+	Ports []string `json:"ports"`
+}
+
+func (r *ACLRule) UnmarshalJSON(b []byte) error {
+	type aclRule ACLRule
+	err := json.Unmarshal(b, (*aclRule)(r))
+	if err != nil {
+		return err
+	}
+	r.Src = append(r.Src, r.Users...)
+	r.Dst = append(r.Dst, r.Ports...)
+	return nil
+}
+
+type Grant struct {
+	Src        []string                     `json:"src"`
+	Dst        []string                     `json:"dst"`
+	IP         []string                     `json:"ip"`
+	Via        []string                     `json:"via"`
+	App        map[string][]json.RawMessage `json:"app"`
+	SrcPosture []string                     `json:"srcPosture"`
+}
+
+type SSHRule struct {
+	Action      string   `json:"action"`
+	Src         []string `json:"src"`
+	Dst         []string `json:"dst"`
+	Users       []string `json:"users"`
+	CheckPeriod string   `json:"checkPeriod"`
+	AcceptEnv   []string `json:"acceptEnv"`
+}
+
+type ACLTest struct {
+	Src             string         `json:"src"`
+	Proto           string         `json:"proto"`
+	Accept          []string       `json:"accept"`
+	Deny            []string       `json:"deny"`
+	SrcPostureAttrs map[string]any `json:"srcPostureAttrs"`
+}
+
+type SSHTest struct {
+	Src    StringList `json:"src"`
+	Dst    StringList `json:"dst"`
+	Accept []string   `json:"accept"`
+	Check  []string   `json:"check"`
+	Deny   []string   `json:"deny"`
+}
+
+type NodeAttr struct {
+	Target []string                     `json:"target"`
+	Attr   []string                     `json:"attr"`
+	App    map[string][]json.RawMessage `json:"app"`
+	IPPool []string                     `json:"ipPool"`
+}
+
+type AutoApprovers struct {
+	Routes   map[string][]string `json:"routes"`
+	ExitNode []string            `json:"exitNode"`
+	Services map[string][]string `json:"services"`
+}
+
+type ExtraDNSRecord struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type DERPMap struct {
+	Regions            map[string]*DERPRegion `json:"Regions"`
+	OmitDefaultRegions bool                   `json:"OmitDefaultRegions"`
+}
+
+type DERPRegion struct {
+	RegionID   int        `json:"RegionID"`
+	RegionCode string     `json:"RegionCode"`
+	RegionName string     `json:"RegionName"`
+	Avoid      bool       `json:"Avoid"`
+	Nodes      []DERPNode `json:"Nodes"`
+}
+
+type DERPNode struct {
+	Name     string `json:"Name"`
+	RegionID int    `json:"RegionID"`
+	HostName string `json:"HostName"`
+	IPv4     string `json:"IPv4"`
+	IPv6     string `json:"IPv6"`
+	STUNPort int    `json:"STUNPort"`
+	STUNOnly bool   `json:"STUNOnly"`
+	DERPPort int    `json:"DERPPort"`
+}
+
+// StringList is a list of strings that may be written as a single string.
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = StringList{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(l))
+}
+
+// A sectionDecoder decodes the section m of a policy, which came from sources,
+// into p.
+type sectionDecoder func(p *Policy, m *jwcc.Member, sources []string) Diagnostics
+
+// policySections are the decoders for each section of Policy, by lower case
+// name since policy keys are case-insensitive.
+var policySections = map[string]sectionDecoder{
+	"acls":                arrayDecoder(func(p *Policy) *[]ACLRule { return &p.ACLs }),
+	"grants":              arrayDecoder(func(p *Policy) *[]Grant { return &p.Grants }),
+	"ssh":                 arrayDecoder(func(p *Policy) *[]SSHRule { return &p.SSH }),
+	"tests":               arrayDecoder(func(p *Policy) *[]ACLTest { return &p.Tests }),
+	"sshtests":            arrayDecoder(func(p *Policy) *[]SSHTest { return &p.SSHTests }),
+	"nodeattrs":           arrayDecoder(func(p *Policy) *[]NodeAttr { return &p.NodeAttrs }),
+	"extradnsrecords":     arrayDecoder(func(p *Policy) *[]ExtraDNSRecord { return &p.ExtraDNSRecords }),
+	"groups":              mapDecoder(func(p *Policy) *map[string][]string { return &p.Groups }),
+	"hosts":               mapDecoder(func(p *Policy) *map[string]string { return &p.Hosts }),
+	"ipsets":              mapDecoder(func(p *Policy) *map[string][]string { return &p.IPSets }),
+	"tagowners":           mapDecoder(func(p *Policy) *map[string][]string { return &p.TagOwners }),
+	"postures":            mapDecoder(func(p *Policy) *map[string][]string { return &p.Postures }),
+	"autoapprovers":       valueDecoder(func(p *Policy) *AutoApprovers { return &p.AutoApprovers }),
+	"defaultsrcposture":   valueDecoder(func(p *Policy) *[]string { return &p.DefaultSrcPosture }),
+	"derpmap":             valueDecoder(func(p *Policy) **DERPMap { return &p.DERPMap }),
+	"disableipv4":         valueDecoder(func(p *Policy) *bool { return &p.DisableIPv4 }),
+	"onecgnatroute":       valueDecoder(func(p *Policy) *string { return &p.OneCGNATRoute }),
+	"randomizeclientport": valueDecoder(func(p *Policy) *bool { return &p.RandomizeClientPort }),
+}
+
+// DecodePolicy decodes doc, such as the output of Merge, into a Policy. Every
+// section is decoded even if an earlier one has the wrong type, and each type
+// mismatch is returned as a Diagnostic. Sections Policy doesn't model are
+// ignored.
+func DecodePolicy(doc *jwcc.Object) (*Policy, Diagnostics) {
+	p := &Policy{}
+	diags := Diagnostics{}
+	for _, m := range doc.Members {
+		decode, ok := policySections[strings.ToLower(m.Key.String())]
+		if !ok {
+			continue
+		}
+		diags = append(diags, decode(p, m, sourcesFromComments(m.Comments().Before))...)
+	}
+	diags.Sort()
+	return p, diags
+}
+
+// arrayDecoder decodes each item of an array section, so that one invalid item
+// doesn't hide problems in the others, and checks its fields.
+func arrayDecoder[T any](field func(p *Policy) *[]T) sectionDecoder {
+	return func(p *Policy, m *jwcc.Member, sources []string) Diagnostics {
+		arr, ok := m.Value.(*jwcc.Array)
+		if !ok {
+			return Diagnostics{diagnosticAt(sourcePath(sources), m, RuleInvalidType, "section [%s] must be an array, got %s", m.Key, valueKind(m.Value))}
+		}
+
+		diags := Diagnostics{}
+		dst := field(p)
+		for i, v := range arr.Values {
+			sources = inheritSources(v, sources)
+			where := fmt.Sprintf("%s[%d]", m.Key, i)
+			diags = append(diags, checkFields[T](sourcePath(sources), m.Key.String(), where, v)...)
+
+			var item T
+			if err := decodeValue(v, &item); err != nil {
+				diags = append(diags, decodeDiagnostic(sourcePath(sources), v, where, err))
+				continue
+			}
+			*dst = append(*dst, item)
+		}
+		return diags
+	}
+}
+
+// mapDecoder decodes each member of an object section, so that one invalid
+// member doesn't hide problems in the others.
+func mapDecoder[T any](field func(p *Policy) *map[string]T) sectionDecoder {
+	return func(p *Policy, m *jwcc.Member, sources []string) Diagnostics {
+		obj, ok := m.Value.(*jwcc.Object)
+		if !ok {
+			return Diagnostics{diagnosticAt(sourcePath(sources), m, RuleInvalidType, "section [%s] must be an object, got %s", m.Key, valueKind(m.Value))}
+		}
+
+		diags := Diagnostics{}
+		dst := field(p)
+		if *dst == nil {
+			*dst = map[string]T{}
+		}
+		for _, member := range obj.Members {
+			sources = inheritSources(member, sources)
+			var value T
+			if err := decodeValue(member.Value, &value); err != nil {
+				diags = append(diags, decodeDiagnostic(sourcePath(sources), member, memberPath(m.Key.String(), member.Key.String()), err))
+				continue
+			}
+			(*dst)[member.Key.String()] = value
+		}
+		return diags
+	}
+}
+
+// valueDecoder decodes a section as a whole.
+func valueDecoder[T any](field func(p *Policy) *T) sectionDecoder {
+	return func(p *Policy, m *jwcc.Member, sources []string) Diagnostics {
+		if err := decodeValue(m.Value, field(p)); err != nil {
+			return Diagnostics{decodeDiagnostic(sourcePath(sources), m, m.Key.String(), err)}
+		}
+		return nil
+	}
+}
+
+func decodeValue(v jwcc.Value, dst any) error {
+	return json.Unmarshal([]byte(v.JSON()), dst)
+}
+
+// decodeDiagnostic returns a Diagnostic for err, from decoding v at where in
+// the policy, positioned at the field with the wrong type if it can be found.
+func decodeDiagnostic(path string, v jwcc.Value, where string, err error) Diagnostic {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return diagnosticAt(path, v, RuleInvalidType, "cannot decode %s: %v", where, err)
+	}
+
+	field := v
+	if m, ok := v.(*jwcc.Member); ok {
+		field = m.Value
+	}
+	at := v
+	if typeErr.Field != "" {
+		for _, key := range strings.Split(typeErr.Field, ".") {
+			obj, ok := field.(*jwcc.Object)
+			if !ok {
+				break
+			}
+			m := obj.Find(key)
+			if m == nil {
+				break
+			}
+			where = fmt.Sprintf("%s.%s", where, m.Key)
+			field, at = m.Value, m
+		}
+	}
+	got, _, _ := strings.Cut(typeErr.Value, " ") // e.g. "number 1.5"
+	if got == "bool" {
+		got = "boolean"
+	}
+	if valueKind(field) != got {
+		return diagnosticAt(path, at, RuleInvalidType, "%s must only contain %s, got %s", where, jsonKind(typeErr.Type), got)
+	}
+	return diagnosticAt(path, at, RuleInvalidType, "%s must be %s, got %s", where, jsonKind(typeErr.Type), got)
+}
+
+// jsonKind returns the kind of JSON value that decodes into t.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	}
+	return "a number"
+}
+
+func sourcePath(sources []string) string {
+	if len(sources) == 0 {
+		return ""
+	}
+	return sources[0]
+}
@@ -0,0 +1,85 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestDecodePolicy(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"RandomizeClientPort": true,
+		"OneCGNATRoute": "mullvad",
+		"derpMap": {"Regions": {"900": {"RegionID": 900, "Nodes": [{"Name": "900a", "HostName": "derp.example.com"}]}}},
+		"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}],
+		"sshTests": [{"src": "alice@example.com", "dst": ["tag:prod"], "accept": ["root"]}],
+		"groups": {"group:sre": ["bob@example.com"]},
+		"extraDNSRecords": [{"Name": "db.example.com", "Value": "100.64.0.1"}],
+		"custom": 1,
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	policy, diags := DecodePolicy(doc.Value.(*jwcc.Object))
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got [%v]", diags)
+	}
+	if !policy.RandomizeClientPort || policy.OneCGNATRoute != "mullvad" {
+		t.Fatalf("unexpected network options [%+v]", policy.NetworkOptions)
+	}
+	if policy.DERPMap == nil || policy.DERPMap.Regions["900"].Nodes[0].HostName != "derp.example.com" {
+		t.Fatalf("unexpected derpMap [%+v]", policy.DERPMap)
+	}
+	if len(policy.ACLs) != 1 || policy.ACLs[0].Dst[0] != "*:*" {
+		t.Fatalf("unexpected acls [%+v]", policy.ACLs)
+	}
+	if len(policy.SSHTests) != 1 || policy.SSHTests[0].Src[0] != "alice@example.com" {
+		t.Fatalf("unexpected sshTests [%+v]", policy.SSHTests)
+	}
+	if len(policy.Groups["group:sre"]) != 1 || policy.ExtraDNSRecords[0].Value != "100.64.0.1" {
+		t.Fatalf("unexpected policy [%+v]", policy)
+	}
+}
+
+func TestDecodePolicyTypeMismatches(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			// acl-combiner: from ` + "`parent.hujson`" + `
+			{"action": "accept", "src": "group:sre", "dst": ["*:*"]},
+			// acl-combiner: from ` + "`child.hujson`" + `
+			{"action": "accept", "src": ["group:sre", 1], "dst": ["*:*"]},
+			"accept",
+		],
+		"groups": {
+			// acl-combiner: from ` + "`child.hujson`" + `
+			"group:sre": "bob@example.com",
+		},
+		"autoApprovers": {"exitNode": {}},
+		"disableIPv4": "yes",
+		"ssh": {},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	_, diags := DecodePolicy(doc.Value.(*jwcc.Object))
+	expected := []string{
+		"autoApprovers.exitNode must be an array, got object",
+		"disableIPv4 must be a boolean, got string",
+		"section [ssh] must be an array, got object",
+		"child.hujson:6:25: acls[1].src must only contain a string, got number",
+		"child.hujson:7:4: acls[2] must be an object, got string",
+		"child.hujson:11:4: groups[\"group:sre\"] must be an array, got string",
+		"parent.hujson:4:25: acls[0].src must be an array, got string",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), diags)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] || d.Rule != RuleInvalidType {
+			t.Errorf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package combiner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// A reference is a name used in a policy that must be defined elsewhere in it,
+// such as a group in an acl's src.
+type reference struct {
+	Name    string
+	Where   string
+	Value   jwcc.Value
+	Sources []string
+}
+
+// referenceFields are the fields of each array section's entries that refer to
+// groups, tags, ipsets, hosts or postures.
+var referenceFields = map[string][]string{
+	"acls":      {"src", "dst", "srcPosture", "users", "ports"},
+	"grants":    {"src", "dst", "srcPosture", "via"},
+	"ssh":       {"src", "dst"},
+	"nodeAttrs": {"target"},
+	"tests":     {"src", "accept", "deny"},
+}
+
+// CheckReferences returns a Diagnostic for each group, tag, ipset, host or
+// posture used in the acls, grants, ssh, nodeAttrs, tests and autoApprovers of
+// doc that isn't defined in it.
+func CheckReferences(doc *jwcc.Object) Diagnostics {
+	policy, diags := DecodePolicy(doc)
+
+	for _, ref := range policyReferences(doc) {
+		kind, section, defined := policy.defines(ref.Name)
+		if defined {
+			continue
+		}
+		diags = append(diags, diagnosticAt(sourcePath(ref.Sources), ref.Value, RuleUndefinedReference, "%s [%s] in %s is not defined in %s", kind, ref.Name, ref.Where, section))
+	}
+
+	diags.Sort()
+	return diags
+}
+
+// defines reports whether name is defined in p, along with the kind of name it
+// is and the section it should be defined in. Users, autogroups and addresses
+// don't need to be defined.
+func (p *Policy) defines(name string) (string, string, bool) {
+	var ok bool
+	switch {
+	case strings.HasPrefix(name, "group:"):
+		_, ok = p.Groups[name]
+		return "group", "groups", ok
+	case isTag(name):
+		_, ok = p.TagOwners[name]
+		return "tag", "tagOwners", ok
+	case strings.HasPrefix(name, "ipset:"):
+		_, ok = p.IPSets[name]
+		return "ipset", "ipsets", ok
+	case strings.HasPrefix(name, "posture:"):
+		_, ok = p.Postures[name]
+		return "posture", "postures", ok
+	}
+
+	if _, isAddr := parseAddrRange(name); isAddr || name == "*" || isUser(name) || strings.Contains(name, ":") {
+		return "", "", true
+	}
+	_, ok = p.Hosts[name]
+	return "host", "hosts", ok
+}
+
+// policyReferences returns the names used in the acls, grants, ssh, nodeAttrs,
+// tests and autoApprovers of doc.
+func policyReferences(doc *jwcc.Object) []reference {
+	refs := []reference{}
+	for _, sectionKey := range []string{"acls", "grants", "ssh", "nodeAttrs", "tests"} {
+		for _, entry := range sectionEntries(doc, sectionKey) {
+			obj, ok := entry.Value.(*jwcc.Object)
+			if !ok {
+				continue
+			}
+
+			for _, field := range referenceFields[sectionKey] {
+				m := obj.FindKey(ast.TextEqual(field))
+				if m == nil {
+					continue
+				}
+				for _, d := range stringValues(m.Value) {
+					name := d.Value.(ast.Text).String()
+					if hasPorts(sectionKey, field) {
+						name, _, _ = splitHostPorts(name)
+					}
+					refs = append(refs, reference{Name: name, Where: fmt.Sprintf("%s[%d].%s", sectionKey, entry.Index, field), Value: d, Sources: entry.Sources})
+				}
+			}
+
+			if sectionKey == "nodeAttrs" {
+				refs = append(refs, appConnectorReferences(obj, entry)...)
+			}
+		}
+	}
+	return append(refs, autoApproverReferences(doc)...)
+}
+
+// hasPorts reports whether the values of field in sectionKey are written as
+// host:port.
+func hasPorts(sectionKey string, field string) bool {
+	return (sectionKey == "acls" && (field == "dst" || field == "ports")) || (sectionKey == "tests" && field != "src")
+}
+
+// appConnectorReferences returns the connector tags of the app connectors in a
+// nodeAttrs entry.
+func appConnectorReferences(obj *jwcc.Object, entry policyEntry) []reference {
+	app := obj.FindKey(ast.TextEqual("app"))
+	if app == nil {
+		return nil
+	}
+	appObj, ok := app.Value.(*jwcc.Object)
+	if !ok {
+		return nil
+	}
+	connectors := appObj.FindKey(ast.TextEqual("tailscale.com/app-connectors"))
+	if connectors == nil {
+		return nil
+	}
+	arr, ok := connectors.Value.(*jwcc.Array)
+	if !ok {
+		return nil
+	}
+
+	refs := []reference{}
+	for i, v := range arr.Values {
+		connector, ok := v.(*jwcc.Object)
+		if !ok {
+			continue
+		}
+		m := connector.FindKey(ast.TextEqual("connectors"))
+		if m == nil {
+			continue
+		}
+		for _, d := range stringValues(m.Value) {
+			where := fmt.Sprintf("nodeAttrs[%d].app[%q][%d].connectors", entry.Index, "tailscale.com/app-connectors", i)
+			refs = append(refs, reference{Name: d.Value.(ast.Text).String(), Where: where, Value: d, Sources: entry.Sources})
+		}
+	}
+	return refs
+}
+
+// autoApproverReferences returns the approvers of the autoApprovers routes,
+// services and exit nodes in doc.
+func autoApproverReferences(doc *jwcc.Object) []reference {
+	section := doc.FindKey(ast.TextEqual("autoApprovers"))
+	if section == nil {
+		return nil
+	}
+	obj, ok := section.Value.(*jwcc.Object)
+	if !ok {
+		return nil
+	}
+
+	refs := []reference{}
+	sources := sourcesFromComments(section.Comments().Before)
+	for _, m := range obj.Members {
+		sources = inheritSources(m, sources)
+
+		switch v := m.Value.(type) {
+		case *jwcc.Object:
+			approverSources := sources
+			for _, a := range v.Members {
+				approverSources = inheritSources(a, approverSources)
+				for _, d := range stringValues(a.Value) {
+					where := fmt.Sprintf("autoApprovers.%s[%q]", m.Key, a.Key.String())
+					refs = append(refs, reference{Name: d.Value.(ast.Text).String(), Where: where, Value: d, Sources: approverSources})
+				}
+			}
+		case *jwcc.Array:
+			approverSources := sources
+			for _, item := range v.Values {
+				approverSources = inheritSources(item, approverSources)
+				for _, d := range stringValues(item) {
+					where := fmt.Sprintf("autoApprovers.%s", m.Key)
+					refs = append(refs, reference{Name: d.Value.(ast.Text).String(), Where: where, Value: d, Sources: approverSources})
+				}
+			}
+		}
+	}
+	return refs
+}
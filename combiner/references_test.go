@@ -0,0 +1,91 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const REFERENCES_POLICY = `{
+	"groups": {
+		"group:sre": ["bob@example.com"],
+	},
+	"tagOwners": {
+		"tag:prod": ["group:sre"],
+	},
+	"hosts": {
+		"vega": "100.64.0.1",
+	},
+	"ipsets": {
+		"ipset:prod": ["192.0.2.0/24"],
+	},
+	"postures": {
+		"posture:latestMac": ["node:os == 'macos'"],
+	},
+	"acls": [
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		{"action": "accept", "src": ["group:sre", "alice@example.com", "autogroup:member"], "dst": ["tag:prod:22", "vega:80", "ipset:prod:*", "192.0.2.1:443", "*:*"], "srcPosture": ["posture:latestMac"]},
+		// acl-combiner: from ` + "`child.hujson`" + `
+		{"action": "accept", "src": ["group:dev"], "dst": ["tag:dev:22", "rigel:80", "ipset:dev:*"], "srcPosture": ["posture:latestLinux"]},
+	],
+	"grants": [
+		// acl-combiner: from ` + "`child.hujson`" + `
+		{"src": ["group:sre"], "dst": ["tag:prod"], "via": ["tag:router"], "ip": ["*"]},
+	],
+	"ssh": [
+		// acl-combiner: from ` + "`child.hujson`" + `
+		{"action": "accept", "src": ["group:ops"], "dst": ["autogroup:self"], "users": ["root"]},
+	],
+	"nodeAttrs": [
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		{"target": ["tag:server"], "app": {"tailscale.com/app-connectors": [{"name": "github", "connectors": ["tag:connector"]}]}},
+	],
+	"tests": [
+		// acl-combiner: from ` + "`tests.hujson`" + `
+		{"src": "group:sre", "accept": ["vega:80"], "deny": ["tag:dev:22"]},
+	],
+	"autoApprovers": {
+		"routes": {
+			// acl-combiner: from ` + "`parent.hujson`" + `
+			"10.0.0.0/24": ["group:sre"],
+			// acl-combiner: from ` + "`child.hujson`" + `
+			"10.0.1.0/24": ["tag:router"],
+		},
+		"exitNode": [
+			// acl-combiner: from ` + "`child.hujson`" + `
+			"tag:exit",
+		],
+	},
+}`
+
+func TestCheckReferences(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(REFERENCES_POLICY))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	diags := CheckReferences(doc.Value.(*jwcc.Object))
+	expected := []string{
+		"child.hujson:21:32: group [group:dev] in acls[1].src is not defined in groups",
+		"child.hujson:21:54: tag [tag:dev] in acls[1].dst is not defined in tagOwners",
+		"child.hujson:21:68: host [rigel] in acls[1].dst is not defined in hosts",
+		"child.hujson:21:80: ipset [ipset:dev] in acls[1].dst is not defined in ipsets",
+		"child.hujson:21:111: posture [posture:latestLinux] in acls[1].srcPosture is not defined in postures",
+		"child.hujson:25:55: tag [tag:router] in grants[0].via is not defined in tagOwners",
+		"child.hujson:29:32: group [group:ops] in ssh[0].src is not defined in groups",
+		"child.hujson:44:20: tag [tag:router] in autoApprovers.routes[\"10.0.1.0/24\"] is not defined in tagOwners",
+		"child.hujson:48:4: tag [tag:exit] in autoApprovers.exitNode is not defined in tagOwners",
+		"parent.hujson:33:15: tag [tag:server] in nodeAttrs[0].target is not defined in tagOwners",
+		"parent.hujson:33:105: tag [tag:connector] in nodeAttrs[0].app[\"tailscale.com/app-connectors\"][0].connectors is not defined in tagOwners",
+		"tests.hujson:37:56: tag [tag:dev] in tests[0].deny is not defined in tagOwners",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected [%d] diagnostics, got [%v]", len(expected), diags)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] || d.Rule != RuleUndefinedReference {
+			t.Errorf("diagnostic [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package combiner
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/jtree"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// A SourceIndex records the file each value of parsed policy files came from,
+// so merged output can be traced back to it. Build it with IndexSources
+// before calling Merge, which moves values between documents.
+type SourceIndex map[jwcc.Value]string
+
+// IndexSources returns the file each value in docs came from.
+func IndexSources(docs ...*ParsedDocument) SourceIndex {
+	idx := SourceIndex{}
+	for _, doc := range docs {
+		idx.add(doc.Object, doc.Path)
+	}
+	return idx
+}
+
+func (idx SourceIndex) add(v jwcc.Value, path string) {
+	idx[v] = path
+	if m, ok := v.(*jwcc.Member); ok {
+		// a member copied by a SectionHandler is a new member with the same value
+		idx[m.Value] = path
+	}
+	for _, child := range childValues(v) {
+		idx.add(child, path)
+	}
+}
+
+// path returns the file v came from. Members copied by a SectionHandler are
+// found by their value.
+func (idx SourceIndex) path(v jwcc.Value) (string, bool) {
+	if path, ok := idx[v]; ok {
+		return path, true
+	}
+	if m, ok := v.(*jwcc.Member); ok {
+		path, ok := idx[m.Value]
+		return path, ok
+	}
+	return "", false
+}
+
+// A SourceMap maps line ranges of merged output to the position each value
+// in them came from.
+type SourceMap struct {
+	// File is the output file, if the output was written to one.
+	File     string          `json:"file,omitempty"`
+	Mappings []SourceMapping `json:"mappings"`
+}
+
+// A SourceMapping maps lines StartLine to EndLine of the output to the
+// position of the value on them in the file it came from.
+type SourceMapping struct {
+	StartLine int    `json:"startLine"` // 1-based
+	EndLine   int    `json:"endLine"`   // 1-based, inclusive
+	Section   string `json:"section"`
+	Path      string `json:"source"`
+	Line      int    `json:"line"`   // 1-based
+	Column    int    `json:"column"` // 1-based
+}
+
+func (m SourceMapping) String() string {
+	return fmt.Sprintf("%s:%d:%d", m.Path, m.Line, m.Column)
+}
+
+// Lookup returns the mapping for the innermost value on line of the output.
+// Several values can share a line, such as the entries of a short array, in
+// which case the first is returned along with any from other files.
+func (sm *SourceMap) Lookup(line int) []SourceMapping {
+	found := []SourceMapping{}
+	for _, m := range sm.Mappings {
+		if line < m.StartLine || line > m.EndLine {
+			continue
+		}
+		if len(found) > 0 {
+			span, foundSpan := m.EndLine-m.StartLine, found[0].EndLine-found[0].StartLine
+			if span > foundSpan {
+				continue
+			}
+			if span < foundSpan {
+				found = found[:0]
+			}
+		}
+		if !slices.ContainsFunc(found, func(f SourceMapping) bool { return f.Path == m.Path }) {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+// SourceMap returns the source map of output, the formatted merged document,
+// for the values whose file is recorded in idx.
+func (idx SourceIndex) SourceMap(merged *jwcc.Object, output []byte) (*SourceMap, error) {
+	doc, err := jwcc.Parse(bytes.NewReader(output))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing output: %v", err)
+	}
+	outObj, ok := doc.Value.(*jwcc.Object)
+	if !ok || len(outObj.Members) != len(merged.Members) {
+		return nil, fmt.Errorf("output doesn't match the merged document")
+	}
+
+	sm := &SourceMap{Mappings: []SourceMapping{}}
+	for i, section := range merged.Members {
+		idx.addMappings(sm, section.Key.String(), section, outObj.Members[i])
+	}
+
+	sort.SliceStable(sm.Mappings, func(i, j int) bool {
+		a, b := sm.Mappings[i], sm.Mappings[j]
+		if a.StartLine != b.StartLine {
+			return a.StartLine < b.StartLine
+		}
+		return a.EndLine > b.EndLine
+	})
+	return sm, nil
+}
+
+// addMappings maps v, and every value inside it, to out, the same value
+// parsed from the output. The comments before out, such as its provenance
+// comments, are mapped to v separately, so they aren't mapped to the value
+// enclosing it and don't make v's own lines less specific than its children.
+func (idx SourceIndex) addMappings(sm *SourceMap, section string, v jwcc.Value, out jwcc.Value) {
+	if path, ok := idx.path(v); ok {
+		loc, outLoc := sourceLocation(v), jwcc.ValueLocation(out)
+		if loc.First.Line > 0 && outLoc.First.Line > 0 {
+			mapping := SourceMapping{
+				StartLine: outLoc.First.Line,
+				EndLine:   outLoc.Last.Line,
+				Section:   section,
+				Path:      path,
+				Line:      loc.First.Line,
+				Column:    loc.First.Column + 1,
+			}
+			if n := commentLines(out.Comments().Before); n > 0 {
+				comments := mapping
+				comments.StartLine, comments.EndLine = mapping.StartLine-n, mapping.StartLine-1
+				sm.Mappings = append(sm.Mappings, comments)
+			}
+			sm.Mappings = append(sm.Mappings, mapping)
+		}
+	}
+
+	children, outChildren := childValues(v), childValues(out)
+	for i := 0; i < len(children) && i < len(outChildren); i++ {
+		idx.addMappings(sm, section, children[i], outChildren[i])
+	}
+}
+
+// childValues returns the members of an object or the values of an array. A
+// member's value is on the same lines as the member, so only the values
+// inside it are returned.
+func childValues(v jwcc.Value) []jwcc.Value {
+	switch v := v.(type) {
+	case *jwcc.Member:
+		return childValues(v.Value)
+	case *jwcc.Object:
+		values := make([]jwcc.Value, 0, len(v.Members))
+		for _, m := range v.Members {
+			values = append(values, m)
+		}
+		return values
+	case *jwcc.Array:
+		return v.Values
+	}
+	return nil
+}
+
+// commentLines returns the number of lines taken by comments, as parsed by
+// jwcc, which ends each line comment with a newline.
+func commentLines(comments []string) int {
+	n := 0
+	for _, c := range comments {
+		n += strings.Count(c, "\n")
+		if c != "" && !strings.HasSuffix(c, "\n") {
+			n++
+		}
+	}
+	return n
+}
+
+// sourceLocation returns the location of v in the file it was parsed from.
+// Members copied by copyMember keep the position of their key, and members
+// built by a SectionHandler, which have no position, use their value's.
+func sourceLocation(v jwcc.Value) jtree.Location {
+	loc := jwcc.ValueLocation(v)
+	if m, ok := v.(*jwcc.Member); ok && loc.First.Line == 0 {
+		loc = jwcc.ValueLocation(m.Value)
+	}
+	return loc
+}
@@ -0,0 +1,95 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestSourceMap(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:a": ["alice@example.com"],
+		},
+		"acls": [
+			{"action": "accept", "src": ["group:a"], "dst": ["*:*"]},
+		],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{Object: parent.Value.(*jwcc.Object), Path: "parent"}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:a": ["bob@example.com"],
+			"group:b": ["carol@example.com"],
+		},
+		"acls": [
+			{
+				"action": "accept",
+				"src":    ["group:a"],
+				"dst":    ["tag:server:22"],
+			},
+		],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{Object: child.Value.(*jwcc.Object), Path: "child"}
+
+	sources := IndexSources(parentDoc, childDoc)
+	sections := Registry{
+		"acls":   HandleArray(),
+		"groups": HandleObject(ConflictUnion),
+	}
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: sections})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	out, err := Format(parentDoc.Object)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	sm, err := sources.SourceMap(parentDoc.Object, out)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	lines := strings.Split(string(out), "\n")
+	lineOf := func(s string) int {
+		for i, l := range lines {
+			if strings.Contains(l, s) {
+				return i + 1
+			}
+		}
+		t.Fatalf("expected [%s] in output, got [%s]", s, out)
+		return 0
+	}
+
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{`"*:*"`, "parent:6:45 in [acls]"},
+		{`"tag:server:22"`, "child:10:5 in [acls]"},
+		{`"group:a": [`, "parent:3:4 in [groups], child:3:16 in [groups]"},
+		{`"group:b": [`, "child:4:4 in [groups]"},
+	}
+	for _, tt := range tests {
+		found := []string{}
+		for _, m := range sm.Lookup(lineOf(tt.output)) {
+			found = append(found, m.String()+" in ["+m.Section+"]")
+		}
+		if strings.Join(found, ", ") != tt.expected {
+			t.Errorf("line with [%s] should be from [%s], got [%s]", tt.output, tt.expected, strings.Join(found, ", "))
+		}
+	}
+
+	// the provenance comment of a member is mapped to the member, not the section
+	found := sm.Lookup(lineOf(`"group:b": [`) - 1)
+	if len(found) != 1 || found[0].String() != "child:4:4" {
+		t.Errorf("comment before [group:b] should be from [child:4:4], got [%v]", found)
+	}
+}
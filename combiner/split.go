@@ -0,0 +1,495 @@
+package combiner
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// A SplitRule moves the entries of a policy using a name matching one of
+// Patterns, such as "tag:finance*" or "group:finance*", to the child file at
+// Path.
+type SplitRule struct {
+	Path     string
+	Patterns []string
+}
+
+// matches reports whether any of names matches one of the rule's patterns.
+func (r SplitRule) matches(names []string) bool {
+	for _, pattern := range r.Patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SplitOptions configure Split.
+type SplitOptions struct {
+	// ParentPath is the path of the parent file. Entries from it, and entries
+	// with no other file, stay in the parent.
+	ParentPath string
+
+	// SourceParentPath is the path of the parent recorded in the provenance
+	// comments of doc. Entries from it stay in the parent, at ParentPath. If
+	// empty, it's found from the provenance of the sections Split doesn't
+	// move, which only the parent sets.
+	SourceParentPath string
+
+	// Rules move entries to child files by the names they use. The last
+	// matching rule wins. Entries matching no rule are placed in the file
+	// recorded in their provenance comments.
+	Rules []SplitRule
+
+	// Warnf, if set, receives warnings, such as entries of a section that
+	// only came from the parent being moved to a child file.
+	Warnf func(format string, args ...any)
+}
+
+// splitSections are the sections Split moves entries of to child files.
+// Other sections, such as the network-wide options, stay in the parent.
+var splitSections = map[string]bool{
+	"acls":            true,
+	"autoApprovers":   true,
+	"extraDNSRecords": true,
+	"grants":          true,
+	"groups":          true,
+	"hosts":           true,
+	"ipsets":          true,
+	"nodeAttrs":       true,
+	"postures":        true,
+	"ssh":             true,
+	"sshTests":        true,
+	"tagOwners":       true,
+	"tests":           true,
+}
+
+// Split decomposes a policy into a parent and child files, the reverse of
+// Merge. Each entry of an array section, and each member of an object section,
+// is placed by the rules in opts, or in the file it came from according to its
+// provenance comments. doc isn't modified.
+//
+// File paths, including those read from provenance comments, must be relative
+// and without ".." components, so the files can't be written outside the
+// directory they're written to.
+//
+// The parent and children are merged again with the default sections before
+// they're returned, and an error listing the differences is returned if the
+// result isn't semantically equal to doc, as reported by DiffPolicies.
+func Split(doc *jwcc.Object, opts SplitOptions) (*ParsedDocument, []*ParsedDocument, error) {
+	clone, err := cloneObject(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.SourceParentPath == "" && hasProvenance(clone) {
+		opts.SourceParentPath, err = provenanceParent(clone)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	s := &splitter{
+		opts:          opts,
+		parent:        &ParsedDocument{Path: opts.ParentPath, Object: &jwcc.Object{}},
+		files:         map[string]*ParsedDocument{},
+		childSections: map[string]bool{},
+		movedSections: map[string][]string{},
+	}
+	for _, section := range clone.Members {
+		s.splitSection(section)
+	}
+	s.warnMovedParentSections()
+
+	children := []*ParsedDocument{}
+	for _, child := range s.files {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Path < children[j].Path
+	})
+
+	for _, d := range append([]*ParsedDocument{s.parent}, children...) {
+		if !IsLocalPath(d.Path) {
+			return nil, nil, fmt.Errorf("file [%s] is outside the output directory, paths must be relative and without [..]", d.Path)
+		}
+	}
+
+	err = verifySplit(doc, s.parent, children)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.parent, children, nil
+}
+
+// IsLocalPath reports whether filePath is relative and has no ".."
+// components, so joining it to a directory stays inside that directory.
+func IsLocalPath(filePath string) bool {
+	if !filepath.IsLocal(filepath.FromSlash(filePath)) {
+		return false
+	}
+	return !slices.Contains(strings.Split(filepath.ToSlash(filePath), "/"), "..")
+}
+
+type splitter struct {
+	opts   SplitOptions
+	parent *ParsedDocument
+	files  map[string]*ParsedDocument
+
+	section       string              // the section being split
+	childSections map[string]bool     // sections with entries from a child file
+	movedSections map[string][]string // sections with entries moved from the parent by a rule, and where to
+}
+
+// fileFor returns the path of the file v belongs in, given the files it came
+// from.
+func (s *splitter) fileFor(v jwcc.Value, sources []string) string {
+	fromParent := len(sources) == 0 || s.isParent(sources[0])
+	if !fromParent {
+		s.childSections[s.section] = true
+	}
+
+	names := valueNames(v)
+	for i := len(s.opts.Rules) - 1; i >= 0; i-- {
+		if s.opts.Rules[i].matches(names) {
+			path := s.opts.Rules[i].Path
+			if fromParent && !s.isParent(path) && !slices.Contains(s.movedSections[s.section], path) {
+				s.movedSections[s.section] = append(s.movedSections[s.section], path)
+			}
+			return path
+		}
+	}
+	if !fromParent {
+		return sources[0]
+	}
+	return s.opts.ParentPath
+}
+
+// isParent reports whether filePath is the parent, either the path it's
+// written to or the path recorded in the provenance comments.
+func (s *splitter) isParent(filePath string) bool {
+	clean := func(p string) string { return filepath.ToSlash(filepath.Clean(p)) }
+	if clean(filePath) == clean(s.opts.ParentPath) {
+		return true
+	}
+	return s.opts.SourceParentPath != "" && clean(filePath) == clean(s.opts.SourceParentPath)
+}
+
+// warnMovedParentSections warns about sections whose entries all came from
+// the parent, but that a rule moved entries of to a child file, since children
+// have to be allowed the section to combine them again. Without provenance
+// comments, every entry is from the parent, so there's nothing to warn about.
+func (s *splitter) warnMovedParentSections() {
+	if s.opts.Warnf == nil || s.opts.SourceParentPath == "" {
+		return
+	}
+	sections := []string{}
+	for section := range s.movedSections {
+		if !s.childSections[section] {
+			sections = append(sections, section)
+		}
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		s.opts.Warnf("section [%s] only had entries from the parent [%s], but rules moved entries of it to [%s]\n", section, s.opts.SourceParentPath, strings.Join(s.movedSections[section], "], ["))
+	}
+}
+
+// doc returns the parent if filePath is the parent's path, and otherwise the
+// child file at filePath, creating it if needed.
+func (s *splitter) doc(filePath string) *ParsedDocument {
+	if s.isParent(filePath) {
+		return s.parent
+	}
+	child, ok := s.files[filePath]
+	if !ok {
+		child = &ParsedDocument{Path: filePath, Object: &jwcc.Object{}}
+		s.files[filePath] = child
+	}
+	return child
+}
+
+func (s *splitter) splitSection(section *jwcc.Member) {
+	parentSection := copyMember(section)
+	_, parentSection.Comments().Before = splitComments(section.Comments().Before)
+
+	sectionKey := section.Key.String()
+	s.section = sectionKey
+	if !splitSections[sectionKey] {
+		stripProvenance(parentSection.Value)
+		s.parent.Object.Members = append(s.parent.Object.Members, parentSection)
+		return
+	}
+
+	sources := sourcesFromComments(section.Comments().Before)
+	switch v := section.Value.(type) {
+	case *jwcc.Array:
+		parentSection.Value = s.splitArray(v, sources, func(doc *ParsedDocument) *jwcc.Array {
+			return sectionArray(doc.Object, sectionKey)
+		})
+
+	case *jwcc.Object:
+		if sectionKey != "autoApprovers" {
+			parentSection.Value = s.splitObject(v, sources, func(doc *ParsedDocument) *jwcc.Object {
+				return sectionObject(doc.Object, sectionKey)
+			})
+			break
+		}
+
+		parentApprovers := &jwcc.Object{}
+		for _, m := range v.Members {
+			approverKey := m.Key.String()
+			parentMember := copyMember(m)
+			_, parentMember.Comments().Before = splitComments(m.Comments().Before)
+
+			sources = inheritSources(m, sources)
+			switch approvers := m.Value.(type) {
+			case *jwcc.Array:
+				parentMember.Value = s.splitArray(approvers, sources, func(doc *ParsedDocument) *jwcc.Array {
+					return sectionArray(sectionObject(doc.Object, sectionKey), approverKey)
+				})
+			case *jwcc.Object:
+				parentMember.Value = s.splitObject(approvers, sources, func(doc *ParsedDocument) *jwcc.Object {
+					return sectionObject(sectionObject(doc.Object, sectionKey), approverKey)
+				})
+			default:
+				stripProvenance(parentMember.Value)
+			}
+			if !emptiedBySplit(parentMember, m.Value) {
+				parentApprovers.Members = append(parentApprovers.Members, parentMember)
+			}
+		}
+		parentSection.Value = parentApprovers
+
+	default:
+		stripProvenance(parentSection.Value)
+	}
+
+	if !emptiedBySplit(parentSection, section.Value) {
+		s.parent.Object.Members = append(s.parent.Object.Members, parentSection)
+	}
+}
+
+// emptiedBySplit reports whether every entry of original was moved to child
+// files, leaving m empty. Sections that were already empty, or that have the
+// author's comments, are kept in the parent.
+func emptiedBySplit(m *jwcc.Member, original jwcc.Value) bool {
+	return len(m.Comments().Before) == 0 && isEmptyValue(m.Value) && !isEmptyValue(original)
+}
+
+func isEmptyValue(v jwcc.Value) bool {
+	switch v := v.(type) {
+	case *jwcc.Array:
+		return len(v.Values) == 0
+	case *jwcc.Object:
+		return len(v.Members) == 0
+	}
+	return false
+}
+
+// splitArray places each item of arr in the array returned by target for the
+// file it belongs in, returning the parent's items.
+func (s *splitter) splitArray(arr *jwcc.Array, sources []string, target func(doc *ParsedDocument) *jwcc.Array) *jwcc.Array {
+	parentArr := &jwcc.Array{}
+	parentArr.Comments().End = arr.Comments().End
+	for _, item := range arr.Values {
+		sources = inheritSources(item, sources)
+		doc := s.doc(s.fileFor(item, sources))
+		stripProvenance(item)
+		if doc == s.parent {
+			parentArr.Values = append(parentArr.Values, item)
+			continue
+		}
+		childArr := target(doc)
+		childArr.Values = append(childArr.Values, item)
+	}
+	return parentArr
+}
+
+// splitObject places each member of obj in the object returned by target for
+// the file it belongs in, returning the parent's members.
+func (s *splitter) splitObject(obj *jwcc.Object, sources []string, target func(doc *ParsedDocument) *jwcc.Object) *jwcc.Object {
+	parentObj := &jwcc.Object{}
+	parentObj.Comments().End = obj.Comments().End
+	for _, m := range obj.Members {
+		sources = inheritSources(m, sources)
+		doc := s.doc(s.fileFor(m, sources))
+		stripProvenance(m)
+		if doc == s.parent {
+			parentObj.Members = append(parentObj.Members, m)
+			continue
+		}
+		childObj := target(doc)
+		childObj.Members = append(childObj.Members, m)
+	}
+	return parentObj
+}
+
+// sectionArray returns the array named key in obj, adding it if needed.
+func sectionArray(obj *jwcc.Object, key string) *jwcc.Array {
+	if m := obj.FindKey(ast.TextEqual(key)); m != nil {
+		return m.Value.(*jwcc.Array)
+	}
+	arr := &jwcc.Array{}
+	obj.Members = append(obj.Members, &jwcc.Member{Key: ast.String(key).Quote(), Value: arr})
+	return arr
+}
+
+// sectionObject returns the object named key in obj, adding it if needed.
+func sectionObject(obj *jwcc.Object, key string) *jwcc.Object {
+	if m := obj.FindKey(ast.TextEqual(key)); m != nil {
+		return m.Value.(*jwcc.Object)
+	}
+	newObj := &jwcc.Object{}
+	obj.Members = append(obj.Members, &jwcc.Member{Key: ast.String(key).Quote(), Value: newObj})
+	return newObj
+}
+
+// valueNames returns the strings in v, and the keys of its members, that a
+// SplitRule may match. Destinations written as host:port are also returned
+// without their ports.
+func valueNames(v jwcc.Value) []string {
+	names := []string{}
+	switch v := v.(type) {
+	case *jwcc.Member:
+		names = append(names, v.Key.String())
+		names = append(names, valueNames(v.Value)...)
+	case *jwcc.Object:
+		for _, m := range v.Members {
+			names = append(names, valueNames(m)...)
+		}
+	case *jwcc.Array:
+		for _, item := range v.Values {
+			names = append(names, valueNames(item)...)
+		}
+	case *jwcc.Datum:
+		if text, ok := v.Value.(ast.Text); ok {
+			names = append(names, text.String())
+			if host, _, ok := splitHostPorts(text.String()); ok {
+				names = append(names, host)
+			}
+		}
+	}
+	return names
+}
+
+// hasProvenance reports whether v, or a value in it, has provenance comments.
+func hasProvenance(v jwcc.Value) bool {
+	if len(sourcesFromComments(v.Comments().Before)) > 0 {
+		return true
+	}
+	return slices.ContainsFunc(childValues(v), hasProvenance)
+}
+
+// provenanceParent returns the file the sections Split doesn't move came
+// from, such as randomizeClientPort, which is the parent of doc. Entries in
+// other sections may come from any file, so they can't tell the parent apart.
+func provenanceParent(doc *jwcc.Object) (string, error) {
+	parents := []string{}
+	for _, section := range doc.Members {
+		if splitSections[section.Key.String()] {
+			continue
+		}
+		sources := sourcesFromComments(section.Comments().Before)
+		for _, v := range childValues(section) {
+			if len(sources) > 0 {
+				break
+			}
+			sources = sourcesFromComments(v.Comments().Before)
+		}
+		if len(sources) > 0 && !slices.Contains(parents, sources[0]) {
+			parents = append(parents, sources[0])
+		}
+	}
+
+	switch len(parents) {
+	case 0:
+		return "", fmt.Errorf("can't find the parent in the provenance comments, no section only the parent sets has one, the parent's path must be given")
+	case 1:
+		return parents[0], nil
+	}
+	return "", fmt.Errorf("can't find the parent in the provenance comments, sections only the parent sets came from [%s], the parent's path must be given", strings.Join(parents, "], ["))
+}
+
+// stripProvenance removes the provenance comments from v and the values in
+// it, keeping the author's comments.
+func stripProvenance(v jwcc.Value) {
+	_, v.Comments().Before = splitComments(v.Comments().Before)
+	switch v := v.(type) {
+	case *jwcc.Member:
+		stripProvenance(v.Value)
+	case *jwcc.Object:
+		for _, m := range v.Members {
+			stripProvenance(m)
+		}
+	case *jwcc.Array:
+		for _, item := range v.Values {
+			stripProvenance(item)
+		}
+	}
+}
+
+// cloneObject returns a copy of obj, with its comments.
+func cloneObject(obj *jwcc.Object) (*jwcc.Object, error) {
+	doc, err := jwcc.Parse(strings.NewReader(jwcc.FormatToString(obj)))
+	if err != nil {
+		return nil, err
+	}
+	return doc.Value.(*jwcc.Object), nil
+}
+
+// verifySplit merges copies of parent and children, as they'd be written, and
+// returns an error if the result isn't semantically equal to doc.
+func verifySplit(doc *jwcc.Object, parent *ParsedDocument, children []*ParsedDocument) error {
+	clone := func(d *ParsedDocument) (*ParsedDocument, error) {
+		obj, err := cloneObject(d.Object)
+		if err != nil {
+			return nil, fmt.Errorf("error formatting %s: %v", d.Path, err)
+		}
+		return &ParsedDocument{Path: d.Path, Object: obj}, nil
+	}
+
+	mergedParent, err := clone(parent)
+	if err != nil {
+		return err
+	}
+	mergedChildren := []*ParsedDocument{}
+	sections := []string{}
+	for _, child := range children {
+		c, err := clone(child)
+		if err != nil {
+			return err
+		}
+		mergedChildren = append(mergedChildren, c)
+		for _, m := range c.Object.Members {
+			sections = append(sections, m.Key.String())
+		}
+	}
+
+	registry, err := DefaultRegistry().Allow(sections)
+	if err != nil {
+		return err
+	}
+	err = Merge(mergedParent, mergedChildren, Options{Sections: registry})
+	if err != nil {
+		return err
+	}
+
+	changes := DiffPolicies(doc, mergedParent.Object)
+	if len(changes) > 0 {
+		lines := []string{}
+		for _, c := range changes {
+			lines = append(lines, c.String())
+		}
+		return fmt.Errorf("split policy does not combine to the same policy:\n%s", strings.Join(lines, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package combiner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const SPLIT_POLICY = `{
+	"acls": [
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		{"action": "accept", "src": ["group:eng"], "dst": ["tag:server:22"]},
+		// acl-combiner: from ` + "`departments/finance.hujson`" + `
+		// finance can reach its own servers
+		{"action": "accept", "src": ["group:finance"], "dst": ["tag:finance:443"]},
+		{"action": "accept", "src": ["group:finance"], "dst": ["tag:finance-db:5432"]},
+	],
+	"autoApprovers": {
+		"routes": {
+			// acl-combiner: from ` + "`departments/finance.hujson`" + `
+			"10.0.10.0/24": ["tag:finance"],
+		},
+	},
+	"groups": {
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		// acl-combiner: and ` + "`departments/finance.hujson`" + `
+		"group:eng": ["alice@example.com", "bob@example.com"],
+
+		// acl-combiner: from ` + "`departments/finance.hujson`" + `
+		"group:finance": ["carol@example.com"],
+	},
+	"tagOwners": {
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		"tag:server":  ["group:eng"],
+		"tag:finance": ["group:finance"],
+	},
+	// acl-combiner: from ` + "`parent.hujson`" + `
+	"randomizeClientPort": true,
+}`
+
+func splitFiles(t *testing.T, opts SplitOptions) map[string]string {
+	t.Helper()
+	parent, children, err := Split(parseDiffDoc(t, SPLIT_POLICY), opts)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	files := map[string]string{}
+	for _, d := range append([]*ParsedDocument{parent}, children...) {
+		formatted, err := Format(d.Object)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		files[d.Path] = string(formatted)
+	}
+	return files
+}
+
+func TestSplitByProvenance(t *testing.T) {
+	files := splitFiles(t, SplitOptions{ParentPath: "parent.hujson"})
+
+	if len(files) != 2 {
+		t.Fatalf("expected a parent and one child, got [%v]", files)
+	}
+
+	expectedParent := `{
+	"acls": [
+		{
+			"action": "accept",
+			"src":    ["group:eng"],
+			"dst":    ["tag:server:22"],
+		},
+	],
+
+	"groups": {"group:eng": ["alice@example.com", "bob@example.com"]},
+
+	"tagOwners": {
+		"tag:server":  ["group:eng"],
+		"tag:finance": ["group:finance"],
+	},
+
+	"randomizeClientPort": true,
+}
+`
+	if files["parent.hujson"] != expectedParent {
+		t.Errorf("expected parent [%s], got [%s]", expectedParent, files["parent.hujson"])
+	}
+
+	expectedChild := `{
+	"acls": [
+		// finance can reach its own servers
+		{
+			"action": "accept",
+			"src":    ["group:finance"],
+			"dst":    ["tag:finance:443"],
+		},
+		{
+			"action": "accept",
+			"src":    ["group:finance"],
+			"dst":    ["tag:finance-db:5432"],
+		},
+	],
+
+	"autoApprovers": {"routes": {"10.0.10.0/24": ["tag:finance"]}},
+	"groups":        {"group:finance": ["carol@example.com"]},
+}
+`
+	if files["departments/finance.hujson"] != expectedChild {
+		t.Errorf("expected child [%s], got [%s]", expectedChild, files["departments/finance.hujson"])
+	}
+}
+
+func TestSplitByRules(t *testing.T) {
+	files := splitFiles(t, SplitOptions{
+		ParentPath: "parent.hujson",
+		Rules: []SplitRule{
+			{Path: "departments/finance/policy.hujson", Patterns: []string{"tag:finance*", "group:finance"}},
+			{Path: "departments/finance-db/policy.hujson", Patterns: []string{"tag:finance-db"}},
+		},
+	})
+
+	expected := map[string][]string{
+		"parent.hujson":                        {"tag:server:22", "group:eng", "randomizeClientPort"},
+		"departments/finance/policy.hujson":    {"tag:finance:443", "10.0.10.0/24", "group:finance", "\"tag:finance\": [\"group:finance\"]"},
+		"departments/finance-db/policy.hujson": {"tag:finance-db:5432"},
+		"departments/finance.hujson":           nil,
+	}
+	for path, values := range expected {
+		content, ok := files[path]
+		if values == nil {
+			if ok {
+				t.Errorf("expected no file [%s], got [%s]", path, content)
+			}
+			continue
+		}
+		for _, v := range values {
+			if !strings.Contains(content, v) {
+				t.Errorf("expected [%s] in [%s], got [%s]", v, path, content)
+			}
+		}
+	}
+	if strings.Contains(files["departments/finance/policy.hujson"], "tag:finance-db") {
+		t.Errorf("expected the last matching rule to win, got [%s]", files["departments/finance/policy.hujson"])
+	}
+}
+
+func TestSplitVerifiesRoundTrip(t *testing.T) {
+	// group:eng is defined twice, and the definitions are combined once split
+	doc := parseDiffDoc(t, `{
+		"groups": {
+			// acl-combiner: from `+"`parent.hujson`"+`
+			"group:eng": ["alice@example.com"],
+			// acl-combiner: from `+"`eng.hujson`"+`
+			"group:eng": ["bob@example.com"],
+		},
+	}`)
+
+	_, _, err := Split(doc, SplitOptions{ParentPath: "parent.hujson", SourceParentPath: "parent.hujson"})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !strings.Contains(err.Error(), "does not combine to the same policy") || !strings.Contains(err.Error(), `+ groups["group:eng"]: "bob@example.com"`) {
+		t.Fatalf("expected the combined group to be reported, got [%v]", err)
+	}
+}
+
+func TestSplitRejectsPathsOutsideOutput(t *testing.T) {
+	for _, filePath := range []string{"../escaped/acls.hujson", "/etc/acls.hujson", "departments/../../acls.hujson"} {
+		doc := parseDiffDoc(t, `{
+			"acls": [
+				// acl-combiner: from `+"`"+filePath+"`"+`
+				{"action": "accept", "src": ["*"], "dst": ["*:*"]},
+			],
+		}`)
+
+		_, _, err := Split(doc, SplitOptions{ParentPath: "parent.hujson", SourceParentPath: "parent.hujson"})
+		if err == nil || !strings.Contains(err.Error(), "outside the output directory") {
+			t.Fatalf("expected an error for [%s], got [%v]", filePath, err)
+		}
+	}
+}
+
+func TestSplitFindsProvenanceParent(t *testing.T) {
+	// the policy was combined from parent.hujson, and is split into policy-parent.hujson
+	var warnings []string
+	files := splitFiles(t, SplitOptions{
+		ParentPath: "policy-parent.hujson",
+		Rules:      []SplitRule{{Path: "departments/finance.hujson", Patterns: []string{"tag:finance"}}},
+		Warnf: func(format string, args ...any) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		},
+	})
+
+	if _, ok := files["parent.hujson"]; ok || len(files) != 2 {
+		t.Fatalf("expected entries from parent.hujson to stay in the parent, got [%v]", files)
+	}
+	for _, v := range []string{"tag:server:22", "group:eng", "\"tag:server\"", "randomizeClientPort"} {
+		if !strings.Contains(files["policy-parent.hujson"], v) {
+			t.Errorf("expected [%s] in the parent, got [%s]", v, files["policy-parent.hujson"])
+		}
+	}
+
+	expected := "section [tagOwners] only had entries from the parent [parent.hujson], but rules moved entries of it to [departments/finance.hujson]\n"
+	if len(warnings) != 1 || warnings[0] != expected {
+		t.Fatalf("expected [%s], got [%v]", expected, warnings)
+	}
+}
+
+func TestSplitRequiresProvenanceParent(t *testing.T) {
+	doc := parseDiffDoc(t, `{
+		"acls": [
+			// acl-combiner: from `+"`parent.hujson`"+`
+			{"action": "accept", "src": ["*"], "dst": ["*:*"]},
+		],
+	}`)
+
+	_, _, err := Split(doc, SplitOptions{ParentPath: "policy-parent.hujson"})
+	if err == nil || !strings.Contains(err.Error(), "can't find the parent") {
+		t.Fatalf("expected an error, got [%v]", err)
+	}
+
+	parent, children, err := Split(doc, SplitOptions{ParentPath: "policy-parent.hujson", SourceParentPath: "parent.hujson"})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(children) != 0 || parent.Object.Find("acls") == nil {
+		t.Fatalf("expected the acls to stay in the parent, got [%v]", children)
+	}
+}
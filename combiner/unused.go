@@ -0,0 +1,133 @@
+package combiner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// An UnusedDefinition is a group, tag, ipset, host or posture defined in a
+// policy that nothing in it refers to.
+type UnusedDefinition struct {
+	Kind    string // e.g. "group"
+	Name    string
+	Section string
+	Sources []string // provenance of the definition
+
+	member *jwcc.Member
+}
+
+func (u UnusedDefinition) String() string {
+	return fmt.Sprintf("%s [%s] defined in %s is never used, from [%s]", u.Kind, u.Name, u.Section, DescribeSources(u.Sources))
+}
+
+// Diagnostic returns u as a Diagnostic with the given severity, positioned at
+// the definition in the file it came from.
+func (u UnusedDefinition) Diagnostic(severity Severity) Diagnostic {
+	var v jwcc.Value
+	if u.member != nil {
+		v = u.member
+	}
+	diag := diagnosticAt(sourcePath(u.Sources), v, RuleUnusedDefinition, "%s [%s] defined in %s is never used", u.Kind, u.Name, u.Section)
+	diag.Severity = severity
+	return diag
+}
+
+// definitionSections are the sections defining names, and the kind of name
+// each defines.
+var definitionSections = []struct {
+	Section string
+	Kind    string
+}{
+	{"groups", "group"},
+	{"tagOwners", "tag"},
+	{"ipsets", "ipset"},
+	{"hosts", "host"},
+	{"postures", "posture"},
+}
+
+// FindUnused returns the groups, tags, ipsets, hosts and postures defined in
+// doc that aren't used by its acls, grants, ssh, nodeAttrs, tests, sshTests or
+// autoApprovers, or by another definition, such as a group owning a tag.
+// Tags only applied to devices are reported too, since devices aren't part of
+// the policy.
+func FindUnused(doc *jwcc.Object) []UnusedDefinition {
+	used := map[string]bool{}
+	for _, ref := range policyReferences(doc) {
+		used[ref.Name] = true
+	}
+	for _, name := range definitionReferences(doc) {
+		used[name] = true
+	}
+
+	unused := []UnusedDefinition{}
+	for _, s := range definitionSections {
+		section := doc.FindKey(ast.TextEqual(s.Section))
+		if section == nil {
+			continue
+		}
+		obj, ok := section.Value.(*jwcc.Object)
+		if !ok {
+			continue
+		}
+
+		sources := sourcesFromComments(section.Comments().Before)
+		for _, m := range obj.Members {
+			sources = inheritSources(m, sources)
+			if used[m.Key.String()] {
+				continue
+			}
+			unused = append(unused, UnusedDefinition{Kind: s.Kind, Name: m.Key.String(), Section: s.Section, Sources: sources, member: m})
+		}
+	}
+	return unused
+}
+
+// definitionReferences returns the names used by the owners in tagOwners, the
+// entries of ipsets, defaultSrcPosture, and the src and dst of sshTests.
+func definitionReferences(doc *jwcc.Object) []string {
+	names := []string{}
+	for _, sectionKey := range []string{"tagOwners", "ipsets"} {
+		section := doc.FindKey(ast.TextEqual(sectionKey))
+		if section == nil {
+			continue
+		}
+		obj, ok := section.Value.(*jwcc.Object)
+		if !ok {
+			continue
+		}
+		for _, m := range obj.Members {
+			for _, d := range stringValues(m.Value) {
+				name := d.Value.(ast.Text).String()
+				if sectionKey == "ipsets" {
+					name = strings.TrimPrefix(name, "add ")
+					name = strings.TrimSpace(strings.TrimPrefix(name, "remove "))
+				}
+				names = append(names, name)
+			}
+		}
+	}
+
+	if m := doc.FindKey(ast.TextEqual("defaultSrcPosture")); m != nil {
+		for _, d := range stringValues(m.Value) {
+			names = append(names, d.Value.(ast.Text).String())
+		}
+	}
+
+	for _, entry := range sectionEntries(doc, "sshTests") {
+		obj, ok := entry.Value.(*jwcc.Object)
+		if !ok {
+			continue
+		}
+		for _, field := range []string{"src", "dst"} {
+			if m := obj.FindKey(ast.TextEqual(field)); m != nil {
+				for _, d := range stringValues(m.Value) {
+					names = append(names, d.Value.(ast.Text).String())
+				}
+			}
+		}
+	}
+	return names
+}
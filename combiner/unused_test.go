@@ -0,0 +1,71 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const UNUSED_POLICY = `{
+	"groups": {
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		"group:sre":   ["bob@example.com"],
+		"group:owners": ["carol@example.com"],
+		// acl-combiner: from ` + "`child.hujson`" + `
+		"group:old":   ["dave@example.com"],
+		"group:ssh":   ["erin@example.com"],
+	},
+	"tagOwners": {
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		"tag:prod":   ["group:owners"],
+		"tag:unused": [],
+	},
+	"hosts": {
+		// acl-combiner: from ` + "`child.hujson`" + `
+		"vega":  "100.64.0.1",
+		"rigel": "100.64.0.2",
+	},
+	"ipsets": {
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		"ipset:prod":   ["add vega", "remove ipset:legacy"],
+		"ipset:legacy": ["192.0.2.0/24"],
+		"ipset:stale":  ["192.0.2.0/24"],
+	},
+	"postures": {
+		// acl-combiner: from ` + "`parent.hujson`" + `
+		"posture:latestMac":   ["node:os == 'macos'"],
+		"posture:latestLinux": ["node:os == 'linux'"],
+	},
+	"defaultSrcPosture": ["posture:latestLinux"],
+	"acls": [
+		{"action": "accept", "src": ["group:sre"], "dst": ["tag:prod:22", "ipset:prod:*"], "srcPosture": ["posture:latestMac"]},
+	],
+	"sshTests": [
+		{"src": "group:ssh", "dst": ["tag:prod"], "deny": ["root"]},
+	],
+}`
+
+func TestFindUnused(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(UNUSED_POLICY))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	unused := FindUnused(doc.Value.(*jwcc.Object))
+	expected := []string{
+		"child.hujson:7:3: group [group:old] defined in groups is never used",
+		"parent.hujson:13:3: tag [tag:unused] defined in tagOwners is never used",
+		"parent.hujson:24:3: ipset [ipset:stale] defined in ipsets is never used",
+		"child.hujson:18:3: host [rigel] defined in hosts is never used",
+	}
+	if len(unused) != len(expected) {
+		t.Fatalf("expected [%d] unused definitions, got [%v]", len(expected), unused)
+	}
+	for i, u := range unused {
+		d := u.Diagnostic(SeverityWarning)
+		if d.Error() != expected[i] || d.Rule != RuleUnusedDefinition || d.Severity != SeverityWarning {
+			t.Errorf("unused definition [%d] should be [%s], got [%s]", i, expected[i], d)
+		}
+	}
+}
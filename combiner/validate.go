@@ -0,0 +1,108 @@
+package combiner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// A DuplicateKey is a key defined more than once in the same object.
+type DuplicateKey struct {
+	Path    string
+	Sources []string // provenance of each occurrence of the key
+
+	last        *jwcc.Member // the last occurrence of the key
+	lastSources []string     // provenance of the last occurrence
+}
+
+func (d DuplicateKey) String() string {
+	return fmt.Sprintf("duplicate key [%s] defined %d times, from [%s]", d.Path, len(d.Sources), strings.Join(d.Sources, "] and ["))
+}
+
+// Diagnostic returns d as a Diagnostic with the given severity, positioned at
+// the last occurrence of the key in the file it came from.
+func (d DuplicateKey) Diagnostic(severity Severity) Diagnostic {
+	path := ""
+	if len(d.lastSources) > 0 {
+		path = d.lastSources[0]
+	}
+	var v jwcc.Value
+	if d.last != nil {
+		v = d.last
+	}
+	diag := diagnosticAt(path, v, RuleDuplicateKey, "%s", d)
+	diag.Severity = severity
+	return diag
+}
+
+// FindDuplicateKeys walks every object in doc and returns the keys that are
+// defined more than once, along with the files each occurrence came from.
+// Go's encoding/json doesn't reject duplicates - https://golang.org/issue/48298.
+func FindDuplicateKeys(doc *jwcc.Object) []DuplicateKey {
+	return duplicateKeysInObject(doc, "", nil)
+}
+
+func duplicateKeysInValue(val jwcc.Value, path string, sources []string) []DuplicateKey {
+	switch v := val.(type) {
+	case *jwcc.Object:
+		return duplicateKeysInObject(v, path, sources)
+	case *jwcc.Array:
+		duplicates := []DuplicateKey{}
+		for i, item := range v.Values {
+			sources = inheritSources(item, sources)
+			duplicates = append(duplicates, duplicateKeysInValue(item, fmt.Sprintf("%s[%d]", path, i), sources)...)
+		}
+		return duplicates
+	}
+	return nil
+}
+
+func duplicateKeysInObject(obj *jwcc.Object, path string, sources []string) []DuplicateKey {
+	duplicates := []DuplicateKey{}
+	occurrences := map[string][]string{}
+	last := map[string]*jwcc.Member{}
+	lastSources := map[string][]string{}
+	keys := []string{}
+
+	for _, m := range obj.Members {
+		sources = inheritSources(m, sources)
+
+		key := m.Key.String()
+		if _, ok := occurrences[key]; !ok {
+			keys = append(keys, key)
+		}
+		occurrences[key] = append(occurrences[key], DescribeSources(sources))
+		last[key], lastSources[key] = m, sources
+
+		duplicates = append(duplicates, duplicateKeysInValue(m.Value, memberPath(path, key), sources)...)
+	}
+
+	for _, key := range keys {
+		if len(occurrences[key]) > 1 {
+			duplicates = append(duplicates, DuplicateKey{
+				Path:        memberPath(path, key),
+				Sources:     occurrences[key],
+				last:        last[key],
+				lastSources: lastSources[key],
+			})
+		}
+	}
+	return duplicates
+}
+
+func memberPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return fmt.Sprintf("%s[%q]", path, key)
+}
+
+// DescribeSources returns sources, the files a value came from, as a list for
+// messages, or "unknown" if there are none.
+func DescribeSources(sources []string) string {
+	if len(sources) == 0 {
+		return "unknown"
+	}
+	return strings.Join(sources, ", ")
+}
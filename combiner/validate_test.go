@@ -0,0 +1,127 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestFindDuplicateKeysNone(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	duplicates := FindDuplicateKeys(doc.Value.(*jwcc.Object))
+	if len(duplicates) != 0 {
+		t.Fatalf("expected no duplicates, got [%v]", duplicates)
+	}
+}
+
+func TestFindDuplicateKeysNested(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group1": [],
+			"group1": [],
+		},
+		"acls": [
+			{"action": "accept", "action": "accept"},
+		],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	duplicates := FindDuplicateKeys(doc.Value.(*jwcc.Object))
+	if len(duplicates) != 2 {
+		t.Fatalf("expected 2 duplicates, got [%v]", duplicates)
+	}
+	if duplicates[0].Path != `groups["group1"]` {
+		t.Fatalf("expected duplicate path [groups[\"group1\"]], got [%v]", duplicates[0].Path)
+	}
+	if duplicates[1].Path != `acls[0]["action"]` {
+		t.Fatalf("expected duplicate path [acls[0][\"action\"]], got [%v]", duplicates[1].Path)
+	}
+}
+
+func TestFindDuplicateKeysProvenance(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host1": "100.99.98.97",
+			"host1": "100.99.98.96",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			{"action": "accept", "src": ["a"], "dst": ["b:*"]},
+			{"action": "accept", "src": ["a"], "src": ["c"], "dst": ["b:*"]},
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = Merge(parentDoc, []*ParsedDocument{childDoc}, Options{Sections: DefaultRegistry()})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	duplicates := FindDuplicateKeys(parentDoc.Object)
+	if len(duplicates) != 2 {
+		t.Fatalf("expected 2 duplicates, got [%v]", duplicates)
+	}
+
+	for _, d := range duplicates {
+		switch d.Path {
+		case `acls[1]["src"]`:
+			if len(d.Sources) != 2 || d.Sources[0] != "child" || d.Sources[1] != "child" {
+				t.Fatalf("expected sources [child child], got [%v]", d.Sources)
+			}
+		case `hosts["host1"]`:
+			if len(d.Sources) != 2 || d.Sources[0] != "parent" || d.Sources[1] != "parent" {
+				t.Fatalf("expected sources [parent parent], got [%v]", d.Sources)
+			}
+		default:
+			t.Fatalf("unexpected duplicate [%v]", d)
+		}
+	}
+}
+
+func TestDuplicateKeyDiagnostic(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		// acl-combiner: from ` + "`child.hujson`" + `
+		"hosts": {
+			"h1": "10.0.0.1",
+			"h1": "10.0.0.2",
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	duplicates := FindDuplicateKeys(doc.Value.(*jwcc.Object))
+	if len(duplicates) != 1 {
+		t.Fatalf("expected 1 duplicate, got [%v]", duplicates)
+	}
+
+	d := duplicates[0].Diagnostic(SeverityWarning)
+	if d.Path != "child.hujson" || d.Line != 5 || d.Column != 4 {
+		t.Fatalf("unexpected position [%s:%d:%d]", d.Path, d.Line, d.Column)
+	}
+	if d.Severity != SeverityWarning || d.Rule != RuleDuplicateKey {
+		t.Fatalf("unexpected severity and rule [%s] [%s]", d.Severity, d.Rule)
+	}
+}
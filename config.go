@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tailscale/hujson"
+)
+
+// config is the format of the file passed with -config. Paths in the file are
+// relative to the directory containing it, except allowPaths, which are matched
+// like -allow-path.
+type config struct {
+	Parent            string            `json:"parent"`
+	Children          []string          `json:"children"`
+	Allow             []string          `json:"allow"`
+	AllowPaths        []configAllowPath `json:"allowPaths"`
+	Conflicts         map[string]string `json:"conflicts"`
+	AllowDuplicates   bool              `json:"allowDuplicates"`
+	EvalTests         bool              `json:"evalTests"`
+	CheckReferences   bool              `json:"checkReferences"`
+	ReportUnused      bool              `json:"reportUnused"`
+	FailUnused        bool              `json:"failUnused"`
+	DiagnosticsFormat string            `json:"diagnosticsFormat"`
+	Output            string            `json:"output"`
+	SourceMap         string            `json:"sourceMap"`
+	Check             string            `json:"check"`
+}
+
+type configAllowPath struct {
+	Path     string   `json:"path"`
+	Allow    []string `json:"allow"`
+	Tags     []string `json:"tags"`
+	Groups   []string `json:"groups"`
+	Prefixes []string `json:"prefixes"`
+}
+
+func loadConfig(path string) (*config, error) {
+	logVerbose("loading config [%v]...\n", path)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = hujson.Standardize(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	cfg := &config{}
+	err = dec.Decode(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// setFlags returns the names of the flags given on the command line.
+func setFlags() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// applyConfig copies values from cfg into any flags that weren't given on the
+// command line. Conflict strategies and path rules are merged, with flags
+// taking precedence for the same section or path.
+func applyConfig(cfg *config, dir string, set map[string]bool) error {
+	if !set["f"] && cfg.Parent != "" {
+		*inParentFile = resolveConfigPath(dir, cfg.Parent)
+	}
+	if !set["d"] {
+		for _, child := range cfg.Children {
+			inChildDirs = append(inChildDirs, resolveConfigPath(dir, child))
+		}
+	}
+	if !set["allow"] {
+		allowedAclSections = append(allowedAclSections, cfg.Allow...)
+	}
+	for _, r := range cfg.AllowPaths {
+		rule := allowPathRule{
+			Pattern:  r.Path,
+			Tags:     r.Tags,
+			Groups:   r.Groups,
+			Prefixes: r.Prefixes,
+		}
+		// -allow-path only replaces the sections in the config, not its tag
+		// namespaces, group owners and allocations
+		if !set["allow-path"] {
+			rule.Sections = r.Allow
+		}
+		// flags take precedence for the same path
+		if existing := allowedPathRules.find(rule.Pattern); existing != nil {
+			if len(existing.Sections) > 0 {
+				rule.Sections = nil
+			}
+			if len(existing.Tags) > 0 {
+				rule.Tags = nil
+			}
+			if len(existing.Groups) > 0 {
+				rule.Groups = nil
+			}
+			if len(existing.Prefixes) > 0 {
+				rule.Prefixes = nil
+			}
+		}
+		if len(rule.Sections) == 0 && len(rule.Tags) == 0 && len(rule.Groups) == 0 && len(rule.Prefixes) == 0 {
+			continue
+		}
+		err := allowedPathRules.add(rule)
+		if err != nil {
+			return err
+		}
+	}
+	for section, strategy := range cfg.Conflicts {
+		if _, ok := conflicts[section]; ok {
+			continue
+		}
+		err := conflicts.add(section, strategy)
+		if err != nil {
+			return err
+		}
+	}
+	if !set["allow-duplicates"] && cfg.AllowDuplicates {
+		*allowDuplicates = true
+	}
+	if !set["eval-tests"] && cfg.EvalTests {
+		*evalTests = true
+	}
+	if !set["check-references"] && cfg.CheckReferences {
+		*checkReferences = true
+	}
+	if !set["report-unused"] && cfg.ReportUnused {
+		*reportUnused = true
+	}
+	if !set["fail-unused"] && cfg.FailUnused {
+		*failUnused = true
+	}
+	if !set["diagnostics-format"] && cfg.DiagnosticsFormat != "" {
+		*diagnosticsFormat = cfg.DiagnosticsFormat
+	}
+	if !set["o"] && cfg.Output != "" {
+		*outFile = resolveConfigPath(dir, cfg.Output)
+	}
+	if !set["sourcemap"] && cfg.SourceMap != "" {
+		*sourceMapPath = resolveConfigPath(dir, cfg.SourceMap)
+	}
+	if !set["check"] && cfg.Check != "" {
+		*checkPath = resolveConfigPath(dir, cfg.Check)
+	}
+	return nil
+}
+
+func resolveConfigPath(dir string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
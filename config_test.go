@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+func resetConfigFlags(t *testing.T) {
+	t.Helper()
+	*inParentFile, *outFile, *checkPath, *allowDuplicates = "", "", "", false
+	inChildDirs, allowedAclSections, allowedPathRules = nil, nil, nil
+	conflicts = conflictStrategies{}
+	t.Cleanup(func() {
+		*inParentFile, *outFile, *checkPath, *allowDuplicates = "", "", "", false
+		inChildDirs, allowedAclSections, allowedPathRules = nil, nil, nil
+		conflicts = conflictStrategies{}
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := loadConfig("testdata/combiner.hujson")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if cfg.Parent != "input-parent.hujson" {
+		t.Fatalf("parent should be [input-parent.hujson], got [%v]", cfg.Parent)
+	}
+	if len(cfg.Children) != 1 || cfg.Children[0] != "departments" {
+		t.Fatalf("children should be [departments], got [%v]", cfg.Children)
+	}
+	if len(cfg.Allow) != 8 {
+		t.Fatalf("allow length should be [8], got [%v]", len(cfg.Allow))
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "combiner.hujson")
+	err := os.WriteFile(path, []byte(`{"parnet": "policy.hujson"}`), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	_, err = loadConfig(path)
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	resetConfigFlags(t)
+
+	cfg := &config{
+		Parent:     "policy-parent.hujson",
+		Children:   []string{"departments", "platform"},
+		Allow:      []string{"acls"},
+		AllowPaths: []configAllowPath{{Path: "departments/finance", Allow: []string{"acls", "tests"}}},
+		Conflicts:  map[string]string{"hosts": "error"},
+		Output:     "policy.hujson",
+	}
+
+	err := applyConfig(cfg, "policies", map[string]bool{})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if *inParentFile != filepath.Join("policies", "policy-parent.hujson") {
+		t.Fatalf("parent file should be relative to the config, got [%v]", *inParentFile)
+	}
+	if len(inChildDirs) != 2 || inChildDirs[1] != filepath.Join("policies", "platform") {
+		t.Fatalf("child dirs should be relative to the config, got [%v]", inChildDirs)
+	}
+	if len(allowedAclSections) != 1 || allowedAclSections[0] != "acls" {
+		t.Fatalf("allowed sections should be [acls], got [%v]", allowedAclSections)
+	}
+	if len(allowedPathRules) != 1 || allowedPathRules[0].Pattern != "departments/finance" {
+		t.Fatalf("path rules should be relative to the children, got [%v]", allowedPathRules)
+	}
+	if conflicts["hosts"] != combiner.ConflictError {
+		t.Fatalf("conflict strategy for hosts should be [error], got [%v]", conflicts["hosts"])
+	}
+	if *outFile != filepath.Join("policies", "policy.hujson") {
+		t.Fatalf("output file should be relative to the config, got [%v]", *outFile)
+	}
+}
+
+func TestApplyConfigFlagsOverride(t *testing.T) {
+	resetConfigFlags(t)
+
+	*inParentFile = "parent.hujson"
+	inChildDirs = childDirs{"teams"}
+	conflicts["hosts"] = combiner.ConflictParentWins
+
+	cfg := &config{
+		Parent:    "policy-parent.hujson",
+		Children:  []string{"departments"},
+		Allow:     []string{"acls"},
+		Conflicts: map[string]string{"hosts": "error", "groups": "error"},
+	}
+
+	err := applyConfig(cfg, ".", map[string]bool{"f": true, "d": true, "conflict": true})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if *inParentFile != "parent.hujson" {
+		t.Fatalf("parent file should come from flag, got [%v]", *inParentFile)
+	}
+	if len(inChildDirs) != 1 || inChildDirs[0] != "teams" {
+		t.Fatalf("child dirs should come from flag, got [%v]", inChildDirs)
+	}
+	if len(allowedAclSections) != 1 {
+		t.Fatalf("allowed sections should come from config, got [%v]", allowedAclSections)
+	}
+	if conflicts["hosts"] != combiner.ConflictParentWins || conflicts["groups"] != combiner.ConflictError {
+		t.Fatalf("conflict strategies should be merged with flags winning, got [%v]", conflicts)
+	}
+}
+
+func TestApplyConfigInvalidConflict(t *testing.T) {
+	resetConfigFlags(t)
+
+	err := applyConfig(&config{Conflicts: map[string]string{"hosts": "last-wins"}}, ".", map[string]bool{})
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+}
+
+func TestApplyConfigAllowPathFlagKeepsGuards(t *testing.T) {
+	resetConfigFlags(t)
+
+	err := allowedPathRules.Set("departments/finance=acls")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	err = allowedPathRules.add(allowPathRule{Pattern: "departments/engineering", Tags: []string{"tag:eng*"}})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	cfg := &config{
+		AllowPaths: []configAllowPath{
+			{Path: "departments/finance", Allow: []string{"acls", "groups"}, Tags: []string{"tag:finance*"}, Groups: []string{"group:finance"}},
+			{Path: "departments/engineering", Allow: []string{"acls", "tests"}, Tags: []string{"tag:engineering*"}, Prefixes: []string{"10.0.0.0/24"}},
+			{Path: "platform", Allow: []string{"acls"}},
+		},
+	}
+	err = applyConfig(cfg, ".", map[string]bool{"allow-path": true})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(allowedPathRules) != 2 {
+		t.Fatalf("expected rules for finance and engineering, got [%v]", allowedPathRules)
+	}
+	finance := allowedPathRules.find("departments/finance")
+	if len(finance.Sections) != 1 || len(finance.Tags) != 1 || finance.Tags[0] != "tag:finance*" || len(finance.Groups) != 1 {
+		t.Fatalf("expected the sections from the flag and the guards from the config, got [%+v]", *finance)
+	}
+	engineering := allowedPathRules.find("departments/engineering")
+	if len(engineering.Sections) != 0 || engineering.Tags[0] != "tag:eng*" || len(engineering.Prefixes) != 1 {
+		t.Fatalf("expected the tags from the flag and the prefixes from the config, got [%+v]", *engineering)
+	}
+}
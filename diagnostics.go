@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// diagnosticsFormats are the values accepted by -diagnostics-format.
+var diagnosticsFormats = []string{"text", "json", "sarif", "github"}
+
+func checkDiagnosticsFormat(format string) error {
+	for _, f := range diagnosticsFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported diagnostics format [%s], expected one of [%s]", format, strings.Join(diagnosticsFormats, ", "))
+}
+
+// writeDiagnostics writes diags to w in format. The json and sarif formats
+// always write a complete document, even when there are no diagnostics.
+func writeDiagnostics(w io.Writer, format string, diags combiner.Diagnostics) error {
+	switch format {
+	case "json":
+		return writeJSONDiagnostics(w, diags)
+	case "sarif":
+		return writeSARIFDiagnostics(w, diags)
+	case "github":
+		return writeGitHubDiagnostics(w, diags)
+	}
+	return writeTextDiagnostics(w, diags)
+}
+
+func writeTextDiagnostics(w io.Writer, diags combiner.Diagnostics) error {
+	for _, d := range diags {
+		if d.Severity == combiner.SeverityWarning {
+			d.Message = "warning: " + d.Message
+		}
+		_, err := fmt.Fprintf(w, "%s\n", d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSONDiagnostics(w io.Writer, diags combiner.Diagnostics) error {
+	if diags == nil {
+		diags = combiner.Diagnostics{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
+
+// writeGitHubDiagnostics writes diags as GitHub Actions workflow commands, so
+// they are shown as annotations on the offending lines -
+// https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions
+func writeGitHubDiagnostics(w io.Writer, diags combiner.Diagnostics) error {
+	for _, d := range diags {
+		props := []string{}
+		if d.Path != "" {
+			props = append(props, "file="+escapeGitHubProperty(filepath.ToSlash(d.Path)))
+		}
+		if d.Line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", d.Line), fmt.Sprintf("col=%d", d.Column))
+		}
+		if d.Rule != "" {
+			props = append(props, "title="+escapeGitHubProperty(d.Rule))
+		}
+
+		command := severity(d)
+		if len(props) > 0 {
+			command += " " + strings.Join(props, ",")
+		}
+		_, err := fmt.Fprintf(w, "::%s::%s\n", command, escapeGitHubData(d.Message))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// severity returns the severity of d, treating diagnostics without one as errors.
+func severity(d combiner.Diagnostic) string {
+	if d.Severity == "" {
+		return string(combiner.SeverityError)
+	}
+	return string(d.Severity)
+}
+
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// sarifLog is the subset of SARIF 2.1.0 written by -diagnostics-format=sarif -
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId,omitempty"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+func writeSARIFDiagnostics(w io.Writer, diags combiner.Diagnostics) error {
+	rules := []sarifRule{}
+	seenRules := map[string]bool{}
+	results := []sarifResult{}
+
+	for _, d := range diags {
+		if d.Rule != "" && !seenRules[d.Rule] {
+			seenRules[d.Rule] = true
+			rules = append(rules, sarifRule{ID: d.Rule})
+		}
+
+		result := sarifResult{
+			RuleID:  d.Rule,
+			Level:   severity(d),
+			Message: sarifMessage{Text: d.Message},
+		}
+		if d.Path != "" {
+			loc := sarifLocation{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(d.Path)},
+				},
+			}
+			if d.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+			}
+			result.Locations = []sarifLocation{loc}
+		}
+		results = append(results, result)
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "tailscale-acl-combiner",
+				InformationURI: "https://github.com/tailscale-dev/tailscale-acl-combiner",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+var testDiagnostics = combiner.Diagnostics{
+	{Path: "departments/finance/acls.hujson", Line: 4, Column: 3, Severity: combiner.SeverityError, Rule: combiner.RuleUnsupportedSection, Message: `unsupported section ["foo"]`},
+	{Path: "policy.hujson", Line: 2, Column: 5, Severity: combiner.SeverityWarning, Rule: combiner.RuleDuplicateKey, Message: "duplicate key [hosts[\"h1\"]]"},
+	{Severity: combiner.SeverityError, Message: "lstat departments: no such file or directory"},
+}
+
+func TestCheckDiagnosticsFormat(t *testing.T) {
+	for _, f := range diagnosticsFormats {
+		if err := checkDiagnosticsFormat(f); err != nil {
+			t.Fatalf("expected no error for [%s], got [%v]", f, err)
+		}
+	}
+	if err := checkDiagnosticsFormat("xml"); err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+}
+
+func TestWriteTextDiagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeDiagnostics(&buf, "text", testDiagnostics)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	expected := `departments/finance/acls.hujson:4:3: unsupported section ["foo"]
+policy.hujson:2:5: warning: duplicate key [hosts["h1"]]
+lstat departments: no such file or directory
+`
+	if buf.String() != expected {
+		t.Fatalf("expected [%s], got [%s]", expected, buf.String())
+	}
+}
+
+func TestWriteJSONDiagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeDiagnostics(&buf, "json", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Fatalf("expected an empty array, got [%s]", buf.String())
+	}
+
+	buf.Reset()
+	err = writeDiagnostics(&buf, "json", testDiagnostics)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	var decoded []map[string]any
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(decoded) != 3 {
+		t.Fatalf("length should be [3], got [%v]", len(decoded))
+	}
+	first := decoded[0]
+	if first["file"] != "departments/finance/acls.hujson" || first["line"] != 4.0 || first["column"] != 3.0 ||
+		first["severity"] != "error" || first["rule"] != "unsupported-section" {
+		t.Fatalf("unexpected diagnostic [%v]", first)
+	}
+}
+
+func TestWriteSARIFDiagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeDiagnostics(&buf, "sarif", testDiagnostics)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	var log sarifLog
+	err = json.Unmarshal(buf.Bytes(), &log)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected log [%v]", log)
+	}
+
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 {
+		t.Fatalf("rules length should be [2], got [%v]", run.Tool.Driver.Rules)
+	}
+	if len(run.Results) != 3 {
+		t.Fatalf("results length should be [3], got [%v]", len(run.Results))
+	}
+	if run.Results[1].Level != "warning" {
+		t.Fatalf("level should be [warning], got [%v]", run.Results[1].Level)
+	}
+	region := run.Results[0].Locations[0].PhysicalLocation.Region
+	if region == nil || region.StartLine != 4 || region.StartColumn != 3 {
+		t.Fatalf("unexpected region [%v]", region)
+	}
+	if len(run.Results[2].Locations) != 0 {
+		t.Fatalf("diagnostic without a path should have no locations, got [%v]", run.Results[2].Locations)
+	}
+}
+
+func TestWriteGitHubDiagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeDiagnostics(&buf, "github", testDiagnostics)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	expected := `::error file=departments/finance/acls.hujson,line=4,col=3,title=unsupported-section::unsupported section ["foo"]
+::warning file=policy.hujson,line=2,col=5,title=duplicate-key::duplicate key [hosts["h1"]]
+::error::lstat departments: no such file or directory
+`
+	if buf.String() != expected {
+		t.Fatalf("expected [%s], got [%s]", expected, buf.String())
+	}
+}
+
+func TestEscapeGitHub(t *testing.T) {
+	if got := escapeGitHubData("100%\nnext"); got != "100%25%0Anext" {
+		t.Fatalf("unexpected data [%s]", got)
+	}
+	if got := escapeGitHubProperty("a:b,c"); got != "a%3Ab%2Cc" {
+		t.Fatalf("unexpected property [%s]", got)
+	}
+}
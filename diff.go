@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// runDiff prints the semantic differences between two combined policies, as
+// text or as Markdown for a pull request comment.
+func runDiff(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := flags.String("format", "text", "format of the changes - text or markdown")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: tailscale-acl-combiner diff [-format text|markdown] <old> <new>\n")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New("missing arguments <old> <new> - the two combined policy files to compare must be provided")
+	}
+	if *format != "text" && *format != "markdown" {
+		return fmt.Errorf("invalid argument -format - unsupported format [%s], expected text or markdown", *format)
+	}
+
+	oldPath, newPath := flags.Arg(0), flags.Arg(1)
+	oldDoc, err := combiner.Parse(oldPath)
+	if err != nil {
+		return err
+	}
+	newDoc, err := combiner.Parse(newPath)
+	if err != nil {
+		return err
+	}
+
+	changes := combiner.DiffPolicies(oldDoc.Object, newDoc.Object)
+	if *format == "markdown" {
+		return writeChangesMarkdown(w, oldPath, newPath, changes)
+	}
+	return writeChangesText(w, changes)
+}
+
+func writeChangesText(w io.Writer, changes []combiner.Change) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(w, "no changes")
+		return err
+	}
+	for _, c := range changes {
+		_, err := fmt.Fprintln(w, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChangesMarkdown writes the changes as a Markdown list per section.
+func writeChangesMarkdown(w io.Writer, oldPath string, newPath string, changes []combiner.Change) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "### Policy changes\n\n")
+	if len(changes) == 0 {
+		fmt.Fprintf(&sb, "No changes between %s and %s.\n", codeSpan(oldPath), codeSpan(newPath))
+		_, err := io.WriteString(w, sb.String())
+		return err
+	}
+
+	plural := "s"
+	if len(changes) == 1 {
+		plural = ""
+	}
+	fmt.Fprintf(&sb, "%d change%s between %s and %s.\n", len(changes), plural, codeSpan(oldPath), codeSpan(newPath))
+
+	section := ""
+	for _, c := range changes {
+		if c.Section != section {
+			section = c.Section
+			fmt.Fprintf(&sb, "\n#### %s\n\n", codeSpan(section))
+		}
+
+		sources := []string{}
+		for _, s := range c.Sources {
+			sources = append(sources, codeSpan(s))
+		}
+		from := "unknown"
+		if len(sources) > 0 {
+			from = strings.Join(sources, ", ")
+		}
+
+		switch c.Kind {
+		case combiner.ChangeAdded:
+			fmt.Fprintf(&sb, "- **Added** %s to %s, from %s\n", codeSpan(c.Value), codeSpan(c.Where), from)
+		case combiner.ChangeRemoved:
+			fmt.Fprintf(&sb, "- **Removed** %s from %s, from %s\n", codeSpan(c.Value), codeSpan(c.Where), from)
+		default:
+			fmt.Fprintf(&sb, "- **Changed** %s from %s to %s, from %s\n", codeSpan(c.Where), codeSpan(c.Old), codeSpan(c.Value), from)
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// codeSpan returns s as Markdown inline code, using a longer delimiter if s
+// contains backticks.
+func codeSpan(s string) string {
+	if strings.Contains(s, "`") {
+		return "`` " + s + " ``"
+	}
+	return "`" + s + "`"
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeDiffFiles(t *testing.T, oldPolicy string, newPolicy string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.hujson"), filepath.Join(dir, "new.hujson")
+	for path, content := range map[string]string{oldPath: oldPolicy, newPath: newPolicy} {
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+	}
+	return oldPath, newPath
+}
+
+func TestRunDiff(t *testing.T) {
+	oldPath, newPath := writeDiffFiles(t, `{
+		"groups": {
+			// acl-combiner: from `+"`parent.hujson`"+`
+			"group:eng": ["alice@example.com"],
+		},
+	}`, `{
+		"groups": {
+			// acl-combiner: from `+"`departments/eng.hujson`"+`
+			"group:eng": ["alice@example.com", "bob@example.com"],
+		},
+		"randomizeClientPort": true,
+	}`)
+
+	var sb strings.Builder
+	err := runDiff([]string{oldPath, newPath}, &sb)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	expected := `+ groups["group:eng"]: "bob@example.com" (from departments/eng.hujson)
++ randomizeClientPort: true (from unknown)
+`
+	if sb.String() != expected {
+		t.Fatalf("expected [%s], got [%s]", expected, sb.String())
+	}
+
+	sb.Reset()
+	err = runDiff([]string{"-format", "markdown", oldPath, newPath}, &sb)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	expected = "### Policy changes\n\n" +
+		"2 changes between `" + oldPath + "` and `" + newPath + "`.\n\n" +
+		"#### `groups`\n\n" +
+		"- **Added** `\"bob@example.com\"` to `groups[\"group:eng\"]`, from `departments/eng.hujson`\n\n" +
+		"#### `randomizeClientPort`\n\n" +
+		"- **Added** `true` to `randomizeClientPort`, from unknown\n"
+	if sb.String() != expected {
+		t.Fatalf("expected [%s], got [%s]", expected, sb.String())
+	}
+}
+
+func TestRunDiffNoChanges(t *testing.T) {
+	oldPath, newPath := writeDiffFiles(t, `{"groups": {"group:eng": ["alice@example.com"]}}`, `{
+		// reformatted
+		"groups": {
+			"group:eng": [
+				"alice@example.com",
+			],
+		},
+	}`)
+
+	var sb strings.Builder
+	err := runDiff([]string{oldPath, newPath}, &sb)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if sb.String() != "no changes\n" {
+		t.Fatalf("expected no changes, got [%s]", sb.String())
+	}
+
+	err = runDiff([]string{"-format", "html", oldPath, newPath}, &sb)
+	if err == nil || !strings.Contains(err.Error(), "unsupported format [html]") {
+		t.Fatalf("expected unsupported format, got [%v]", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// runImpact prints the access gained and lost between two combined policies.
+func runImpact(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("impact", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: tailscale-acl-combiner impact <old> <new>\n")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New("missing arguments <old> <new> - the two combined policy files to compare must be provided")
+	}
+
+	oldDoc, err := combiner.Parse(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	newDoc, err := combiner.Parse(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	impact, diags := combiner.DiffAccess(oldDoc.Object, newDoc.Object)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	if len(impact.Gained) == 0 && len(impact.Lost) == 0 {
+		_, err := fmt.Fprintln(w, "no change in access")
+		return err
+	}
+
+	var sb strings.Builder
+	for _, a := range impact.Gained {
+		fmt.Fprintf(&sb, "+ %s (from %s)\n", a, combiner.DescribeSources(a.Sources))
+	}
+	for _, a := range impact.Lost {
+		fmt.Fprintf(&sb, "- %s (from %s)\n", a, combiner.DescribeSources(a.Sources))
+	}
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
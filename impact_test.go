@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunImpact(t *testing.T) {
+	oldPath, newPath := writeDiffFiles(t, `{
+		"groups": {"group:finance": ["finance1@example.com"]},
+		"acls": [
+			// acl-combiner: from `+"`departments/finance/acls.hujson`"+`
+			{"action": "accept", "src": ["group:finance"], "dst": ["tag:demo-infra:22"]},
+		],
+	}`, `{
+		"groups": {"group:finance": ["finance2@example.com"]},
+		"acls": [
+			// acl-combiner: from `+"`departments/finance/acls.hujson`"+`
+			{"action": "accept", "src": ["group:finance"], "dst": ["tag:demo-infra:22"]},
+		],
+	}`)
+
+	var sb strings.Builder
+	err := runImpact([]string{oldPath, newPath}, &sb)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	expected := `+ finance2@example.com -> tag:demo-infra:22 (from departments/finance/acls.hujson)
+- finance1@example.com -> tag:demo-infra:22 (from departments/finance/acls.hujson)
+`
+	if sb.String() != expected {
+		t.Fatalf("expected [%s], got [%s]", expected, sb.String())
+	}
+
+	sb.Reset()
+	err = runImpact([]string{oldPath, oldPath}, &sb)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if sb.String() != "no change in access\n" {
+		t.Fatalf("expected no change in access, got [%s]", sb.String())
+	}
+}
@@ -5,48 +5,48 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io/fs"
+	"io"
 	"log"
+	"net/netip"
 	"os"
+	"path"
 	"path/filepath"
-	"sort"
 	"strings"
 
-	"github.com/creachadair/jtree/ast"
 	"github.com/creachadair/jtree/jwcc"
-	"github.com/tailscale/hujson"
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
 )
 
 var (
 	inParentFile       = flag.String("f", "", "parent file to load from")
-	inChildDir         = flag.String("d", "", "directory to process files from")
+	configPath         = flag.String("config", "", "combiner configuration file to load, flags override its values")
 	outFile            = flag.String("o", "", "file to write output to")
+	checkPath          = flag.String("check", "", "file to compare the generated output to, exits non-zero with a diff if they differ")
+	sourceMapPath      = flag.String("sourcemap", "", "file to write a source map to, mapping each line of the output to the file, line and column it came from")
 	verbose            = flag.Bool("v", false, "enable verbose logging")
+	allowDuplicates    = flag.Bool("allow-duplicates", false, "warn instead of failing when a key is defined more than once in the combined output")
+	evalTests          = flag.Bool("eval-tests", false, "evaluate the tests and sshTests in the combined output against its acls, grants and ssh rules, failing if any test fails")
+	checkReferences    = flag.Bool("check-references", false, "check that the groups, tags, ipsets, hosts and postures used in the combined output are defined in it")
+	reportUnused       = flag.Bool("report-unused", false, "warn about groups, tags, ipsets, hosts and postures in the combined output that nothing uses")
+	failUnused         = flag.Bool("fail-unused", false, "like -report-unused, but failing if anything is unused")
+	diagnosticsFormat  = flag.String("diagnostics-format", "text", "format of errors and warnings written to stderr - text, json, sarif or github")
+	inChildDirs        childDirs
 	allowedAclSections aclSections
-
-	// TODO: anything special to do with top-level properties - https://tailscale.com/kb/1337/acl-syntax#network-policy-options ?
-	// TODO: worry about casing? mainly -allow arg not matching casing?
-	preDefinedAclSections = map[string]SectionHandler{
-		"acls":            handleArray(),
-		"autoApprovers":   handleAutoApprovers(),
-		"extraDNSRecords": handleArray(),
-		"grants":          handleArray(),
-		"groups":          handleObject(),
-		"ipsets":          handleObject(),
-		"nodeAttrs":       handleArray(), // TODO: need to merge anything?
-		"postures":        handleObject(),
-		"ssh":             handleArray(),
-		"tagOwners":       handleObject(),
-		"tests":           handleArray(),
-		"sshTests":        handleArray(),
-		"hosts":           handleObject(),
-	}
+	allowedPathRules   allowPathRules
+	conflicts          = conflictStrategies{}
 )
 
-type ParsedDocument struct {
-	Path   string
-	Object *jwcc.Object
+type childDirs []string
+
+func (d *childDirs) String() string {
+	return fmt.Sprintf("%s", *d)
+}
+
+func (d *childDirs) Set(value string) error {
+	*d = append(*d, value)
+	return nil
 }
+
 type aclSections []string
 
 func (i *aclSections) String() string {
@@ -61,8 +61,157 @@ func (i *aclSections) Set(value string) error {
 	return nil
 }
 
+type conflictStrategies map[string]combiner.ConflictStrategy
+
+func (c conflictStrategies) String() string {
+	return fmt.Sprintf("%v", map[string]combiner.ConflictStrategy(c))
+}
+
+func (c conflictStrategies) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		section, strategy, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid conflict strategy [%s], expected [section=strategy]", v)
+		}
+		err := c.add(section, strategy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c conflictStrategies) add(section string, strategy string) error {
+	s, err := combiner.ParseConflictStrategy(strategy)
+	if err != nil {
+		return fmt.Errorf("%v for section [%s]", err, section)
+	}
+	c[section] = s
+	return nil
+}
+
+// allowPathRule is a -allow-path, -tag-namespace, -group-owner or -allocate flag, limiting the sections allowed from,
+// and the tags, groups and addresses used by, child files under paths matching Pattern.
+type allowPathRule struct {
+	Pattern  string
+	Sections []string
+	Tags     []string
+	Groups   []string
+	Prefixes []string
+}
+
+func (r allowPathRule) String() string {
+	return fmt.Sprintf("%s=%s", r.Pattern, strings.Join(r.Sections, ","))
+}
+
+type allowPathRules []allowPathRule
+
+func (r *allowPathRules) String() string {
+	return fmt.Sprintf("%s", *r)
+}
+
+func (r *allowPathRules) Set(value string) error {
+	pattern, sections, ok := strings.Cut(value, "=")
+	if !ok || sections == "" {
+		return fmt.Errorf("invalid path rule [%s], expected [path=section,section]", value)
+	}
+	return r.add(allowPathRule{Pattern: pattern, Sections: strings.Split(sections, ",")})
+}
+
+// add adds the sections, tags, groups and prefixes in rule, updating the rule for the
+// same pattern if there is one.
+func (r *allowPathRules) add(rule allowPathRule) error {
+	rule.Pattern = path.Clean(filepath.ToSlash(rule.Pattern))
+	if _, err := path.Match(rule.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid path rule [%s]: %v", rule.Pattern, err)
+	}
+	if len(rule.Sections) == 0 && len(rule.Tags) == 0 && len(rule.Groups) == 0 && len(rule.Prefixes) == 0 {
+		return fmt.Errorf("invalid path rule [%s], no sections allowed", rule.Pattern)
+	}
+	for _, tag := range rule.Tags {
+		if _, err := path.Match(tag, ""); err != nil || !strings.HasPrefix(tag, "tag:") {
+			return fmt.Errorf("invalid tag namespace [%s] for path rule [%s], expected a pattern like [tag:finance*]", tag, rule.Pattern)
+		}
+	}
+	for _, group := range rule.Groups {
+		if _, err := path.Match(group, ""); err != nil || !strings.HasPrefix(group, "group:") {
+			return fmt.Errorf("invalid group [%s] for path rule [%s], expected a pattern like [group:finance*]", group, rule.Pattern)
+		}
+	}
+	for _, prefix := range rule.Prefixes {
+		if _, err := netip.ParsePrefix(prefix); err != nil {
+			return fmt.Errorf("invalid allocation [%s] for path rule [%s], expected a prefix like [10.0.10.0/24]", prefix, rule.Pattern)
+		}
+	}
+
+	for i := range *r {
+		existing := &(*r)[i]
+		if existing.Pattern != rule.Pattern {
+			continue
+		}
+		if len(rule.Sections) > 0 {
+			existing.Sections = rule.Sections
+		}
+		if len(rule.Tags) > 0 {
+			existing.Tags = rule.Tags
+		}
+		if len(rule.Groups) > 0 {
+			existing.Groups = rule.Groups
+		}
+		if len(rule.Prefixes) > 0 {
+			existing.Prefixes = rule.Prefixes
+		}
+		return nil
+	}
+	*r = append(*r, rule)
+	return nil
+}
+
+// find returns the rule for pattern, or nil if there is none.
+func (r allowPathRules) find(pattern string) *allowPathRule {
+	pattern = path.Clean(filepath.ToSlash(pattern))
+	for i := range r {
+		if r[i].Pattern == pattern {
+			return &r[i]
+		}
+	}
+	return nil
+}
+
+// pathRuleValues is a flag adding values, such as the tags of -tag-namespace,
+// to the rule for a path in allowedPathRules.
+type pathRuleValues struct {
+	rules  *allowPathRules
+	format string // e.g. "path=tag:prefix*,tag:prefix*"
+	rule   func(pattern string, values []string) allowPathRule
+}
+
+func (v pathRuleValues) String() string {
+	return ""
+}
+
+func (v pathRuleValues) Set(value string) error {
+	pattern, values, ok := strings.Cut(value, "=")
+	if !ok || values == "" {
+		return fmt.Errorf("invalid value [%s], expected [%s]", value, v.format)
+	}
+	return v.rules.add(v.rule(pattern, strings.Split(values, ",")))
+}
+
+// commands are the subcommands, run as tailscale-acl-combiner <command> [flags] [args].
+var commands = map[string]func(args []string, w io.Writer) error{
+	"blame":  runBlame,
+	"diff":   runDiff,
+	"impact": runImpact,
+	"split":  runSplit,
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "usage: tailscale-acl-combiner [flags]\n")
+	fmt.Fprintf(os.Stderr, "       tailscale-acl-combiner blame -sourcemap <file> <line>\n")
+	fmt.Fprintf(os.Stderr, "       tailscale-acl-combiner diff [-format text|markdown] <old> <new>\n")
+	fmt.Fprintf(os.Stderr, "       tailscale-acl-combiner impact <old> <new>\n")
+	fmt.Fprintf(os.Stderr, "       tailscale-acl-combiner split [-parent <file>] [-source-parent <file>] [-rule <path>=<patterns>] [-o <dir>] <policy>\n")
 	flag.PrintDefaults()
 }
 
@@ -70,18 +219,64 @@ func checkArgs() error {
 	if *inParentFile == "" {
 		return errors.New("missing argument -f - a parent file must be provided")
 	}
-	if *inChildDir == "" {
+	if len(inChildDirs) == 0 {
 		return errors.New("missing argument -d - a directory of child files to process must be provided")
 	}
 	if len(allowedAclSections) == 0 {
 		return errors.New("missing argument -allow - a list of acl sections to allow from children must be provided - e.g. -allow=acls,ssh")
 	}
+	if err := checkDiagnosticsFormat(*diagnosticsFormat); err != nil {
+		return fmt.Errorf("invalid argument -diagnostics-format - %v", err)
+	}
 	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			err := command(os.Args[2:], os.Stdout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	flag.Var(&inChildDirs, "d", "directory to process files from (may be repeated)")
 	flag.Var(&allowedAclSections, "allow", "acl sections to allow from children")
+	flag.Var(&allowedPathRules, "allow-path", "acl sections to allow from children under a path relative to -d, instead of -allow - e.g. -allow-path=departments/finance=acls,tests (may be repeated, the most specific matching rule wins)")
+	flag.Var(pathRuleValues{
+		rules:  &allowedPathRules,
+		format: "path=tag:prefix*,tag:prefix*",
+		rule:   func(pattern string, tags []string) allowPathRule { return allowPathRule{Pattern: pattern, Tags: tags} },
+	}, "tag-namespace", "tags that child files under a path may own and reference - e.g. -tag-namespace=departments/finance=tag:finance* (may be repeated, the most specific matching rule wins)")
+	flag.Var(pathRuleValues{
+		rules:  &allowedPathRules,
+		format: "path=group:name,group:name",
+		rule: func(pattern string, groups []string) allowPathRule {
+			return allowPathRule{Pattern: pattern, Groups: groups}
+		},
+	}, "group-owner", "groups that only child files under a path may define or extend - e.g. -group-owner=departments/finance=group:finance* (may be repeated, the most specific matching rule wins)")
+	flag.Var(pathRuleValues{
+		rules:  &allowedPathRules,
+		format: "path=10.0.10.0/24,10.0.11.0/24",
+		rule: func(pattern string, prefixes []string) allowPathRule {
+			return allowPathRule{Pattern: pattern, Prefixes: prefixes}
+		},
+	}, "allocate", "address blocks allocated to child files under a path, their autoApprovers routes and ipsets must be inside them - e.g. -allocate=departments/finance=10.0.10.0/24 (may be repeated, the most specific matching rule wins)")
+	flag.Var(conflicts, "conflict", "strategy for keys defined in more than one file, per section - e.g. -conflict=hosts=error,groups=union (strategies: error, union, parent-wins, first-child-wins)")
 	flag.Parse()
+	if *configPath != "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = applyConfig(cfg, filepath.Dir(*configPath), setFlags())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	argsErr := checkArgs()
 	if argsErr != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", argsErr)
@@ -89,380 +284,178 @@ func main() {
 		os.Exit(1)
 	}
 
-	var parentDoc *ParsedDocument
-	var err error
-	if *inParentFile != "" {
-		parentDoc, err = parse(*inParentFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		parentDoc = &ParsedDocument{
-			Object: &jwcc.Object{
-				Members: make([]*jwcc.Member, 0),
-			},
-		}
-	}
+	opts := combiner.Options{Logf: logVerbose}
 
-	childDocs, err := gatherChildren(*inChildDir)
+	registry, err := getRegistry(conflicts)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	aclSections, err := getAllowedSections(allowedAclSections, preDefinedAclSections)
+	opts.Sections, err = registry.Allow(allowedAclSections)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("invalid [-allow] flag: %v", err)
 	}
+	logVerbose("allowing ACL sections [%v]\n", opts.Sections.Names())
 
-	err = mergeDocs(aclSections, parentDoc, childDocs)
+	opts.Rules, err = getPathRules(allowedPathRules, registry)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	outputFile(parentDoc.Object)
-}
+	// keep going after a problem so that every problem is reported at once
+	diags := combiner.Diagnostics{}
 
-func getAllowedSections(allowedAclSections []string, preDefinedAclSections map[string]SectionHandler) (map[string]SectionHandler, error) {
-	aclSections := map[string]SectionHandler{}
-	for _, v := range allowedAclSections {
-		if preDefinedAclSections[v] == nil {
-			return nil, fmt.Errorf("unsupported section [%s] specified in [-allow] flag", v)
+	var parentDoc *combiner.ParsedDocument
+	if *inParentFile != "" {
+		logVerbose("parsing [%v]...\n", *inParentFile)
+		parentDoc, err = combiner.Parse(*inParentFile)
+		diags = append(diags, combiner.AsDiagnostics(err)...)
+	} else {
+		parentDoc = &combiner.ParsedDocument{
+			Object: &jwcc.Object{
+				Members: make([]*jwcc.Member, 0),
+			},
 		}
-		aclSections[v] = preDefinedAclSections[v]
 	}
-	logVerbose("allowing ACL sections [%v]\n", aclSections)
-	return aclSections, nil
-}
 
-type SectionHandler func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member)
-
-func handleArray() SectionHandler {
-	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) {
-		if childSection == nil {
-			return
-		}
-
-		newArr := existingOrNewArray(*parent, sectionKey)
-
-		pathCommentAlreadyAdded := false
-		for _, v := range childSection.Value.(*jwcc.Array).Values {
-			newArr.Values = append(newArr.Values, v)
-
-			if !pathCommentAlreadyAdded {
-				pathComment(v, childPath)
-				pathCommentAlreadyAdded = true
-			}
-		}
-
-		upsertMember(parent, sectionKey, newArr)
+	childDocs := []*combiner.ParsedDocument{}
+	for _, dir := range inChildDirs {
+		docs, err := combiner.GatherChildren(dir, opts)
+		diags = append(diags, combiner.AsDiagnostics(err)...)
+		childDocs = append(childDocs, docs...)
 	}
-}
-
-func handleObject() SectionHandler {
-	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) {
-		if childSection == nil {
-			return
-		}
-
-		newObj := existingOrNewObject(*parent, sectionKey)
 
-		for _, m := range childSection.Value.(*jwcc.Object).Members {
-			existingMemberIdx := newObj.IndexKey(ast.TextEqual(m.Key.String()))
-			if existingMemberIdx != -1 {
-				existingMember := newObj.Members[existingMemberIdx]
-				existingArr, existingIsArr := existingMember.Value.(*jwcc.Array)
-				newArr, newIsArr := m.Value.(*jwcc.Array)
-
-				if existingIsArr && newIsArr {
-					mergedArr := mergeArraysWithDedup(existingArr, newArr)
-
-					if existingComments := existingArr.Comments(); existingComments != nil {
-						if mergedComments := mergedArr.Comments(); mergedComments != nil {
-							mergedComments.Before = existingComments.Before
-							mergedComments.Line = existingComments.Line
-							mergedComments.End = existingComments.End
-						}
-					}
-
-					existingMember.Value = mergedArr
-
-					addMergeComment(existingMember, parentPath, childPath)
-					continue
-				}
-			}
-
-			newMember := &jwcc.Member{Key: m.Key, Value: m.Value}
-			newObj.Members = append(newObj.Members, newMember)
-
-			newMember.Comments().Before = []string{fmt.Sprintf("from `%s`", childPath)}
-		}
-
-		upsertMember(parent, sectionKey, newObj)
+	var sources combiner.SourceIndex
+	if parentDoc != nil && *sourceMapPath != "" {
+		// index before merging, which moves values into the parent
+		sources = combiner.IndexSources(append([]*combiner.ParsedDocument{parentDoc}, childDocs...)...)
 	}
-}
 
-func mergeArraysWithDedup(existing *jwcc.Array, new *jwcc.Array) *jwcc.Array {
-	result := &jwcc.Array{
-		Values: make([]jwcc.Value, 0, len(existing.Values)+len(new.Values)),
+	if parentDoc != nil {
+		err = combiner.Merge(parentDoc, childDocs, opts)
+		diags = append(diags, combiner.AsDiagnostics(err)...)
 	}
 
-	seen := make(map[string]bool)
-
-	for _, v := range existing.Values {
-		key := v.String()
-		if !seen[key] {
-			seen[key] = true
-			result.Values = append(result.Values, v)
-		}
+	// warnings are reported with the problems found in the combined policy
+	if diags.HasErrors() {
+		reportDiagnostics(diags)
 	}
 
-	for _, v := range new.Values {
-		key := v.String()
-		if !seen[key] {
-			seen[key] = true
-			result.Values = append(result.Values, v)
-		}
-	}
+	diags = append(diags, combiner.UnmatchedRules(opts.Rules, childDocs)...)
 
-	return result
-}
+	_, policyDiags := combiner.DecodePolicy(parentDoc.Object)
+	diags = append(diags, policyDiags...)
 
-func addMergeComment(member *jwcc.Member, parentPath string, childPath string) {
-	existingComments := member.Comments().Before
-
-	if len(existingComments) == 0 {
-		existingComments = []string{fmt.Sprintf("from `%s`", parentPath)}
-	}
-
-	newComment := fmt.Sprintf("and `%s`", childPath)
-	for _, c := range existingComments {
-		if c == newComment || c == fmt.Sprintf("from `%s`", childPath) {
-			return
+	for _, d := range combiner.FindDuplicateKeys(parentDoc.Object) {
+		severity := combiner.SeverityError
+		if *allowDuplicates {
+			severity = combiner.SeverityWarning
 		}
+		diags = append(diags, d.Diagnostic(severity))
 	}
-
-	member.Comments().Before = append(existingComments, newComment)
-}
-
-func handleAutoApprovers() SectionHandler {
-	// https://tailscale.com/kb/1337/acl-syntax#auto-approvers-autoapprovers
-	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) {
-		if childSection == nil {
-			return
-		}
-		newObj := existingOrNewObject(*parent, sectionKey)
-
-		childSectionObj := childSection.Value.(*jwcc.Object)
-
-		childExitNodeProps := childSectionObj.FindKey(ast.TextEqual("exitNode"))
-		arrayFn := handleArray()
-		arrayFn("exitNode", parentPath, newObj, childPath, childExitNodeProps)
-
-		childRoutesProps := childSectionObj.FindKey(ast.TextEqual("routes"))
-		objectFn := handleObject()
-		objectFn("routes", parentPath, newObj, childPath, childRoutesProps)
-
-		childServicesProps := childSectionObj.FindKey(ast.TextEqual("services"))
-		objectFn("services", parentPath, newObj, childPath, childServicesProps)
-
-		newObj.Sort()
-		upsertMember(parent, sectionKey, newObj)
+	if *evalTests {
+		diags = append(diags, combiner.EvaluateTests(parentDoc.Object)...)
+		diags = append(diags, combiner.EvaluateSSHTests(parentDoc.Object)...)
 	}
-}
-
-func upsertMember[V *jwcc.Object | *jwcc.Array](doc *jwcc.Object, key string, val V) {
-	keyAst := ast.String(key)
-	index := doc.IndexKey(ast.TextEqual(key))
-	if index != -1 {
-		doc.Members[index] = &jwcc.Member{Key: keyAst.Quote(), Value: jwcc.Value(val)}
-	} else {
-		doc.Members = append(doc.Members, &jwcc.Member{Key: keyAst.Quote(), Value: jwcc.Value(val)})
+	if *checkReferences {
+		diags = append(diags, combiner.CheckReferences(parentDoc.Object)...)
 	}
-}
-
-func pathComment(val jwcc.Value, path string) {
-	// TODO: preserve existing comments
-	val.Comments().Before = []string{fmt.Sprintf("from `%s`", path)}
-}
-
-func addParentPathComments(parentDoc *ParsedDocument) error {
-	for _, parentSection := range parentDoc.Object.Members {
-		logVerbose("adding parent path comment to [%s]\n", parentSection.Key)
-		switch parentSection.Value.(type) {
-		default:
-			pathComment(parentSection, parentDoc.Path)
-		case *jwcc.Array:
-			for _, val := range parentSection.Value.(*jwcc.Array).Values {
-				pathComment(val, parentDoc.Path)
-			}
-		case *jwcc.Object:
-			for _, member := range parentSection.Value.(*jwcc.Object).Members {
-				pathComment(member, parentDoc.Path)
-			}
+	if *reportUnused || *failUnused {
+		severity := combiner.SeverityWarning
+		if *failUnused {
+			severity = combiner.SeverityError
+		}
+		for _, u := range combiner.FindUnused(parentDoc.Object) {
+			diags = append(diags, u.Diagnostic(severity))
 		}
 	}
-	return nil
-}
+	reportDiagnostics(diags)
 
-func mergeDocs(sections map[string]SectionHandler, parentDoc *ParsedDocument, childDocs []*ParsedDocument) error {
-	err := addParentPathComments(parentDoc)
+	formatted, err := combiner.Format(parentDoc.Object)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for _, child := range childDocs {
-		if child.Path == parentDoc.Path {
-			logVerbose("skipping [%s], same doc as parent\n", child.Path)
-			continue
-		}
-
-		for sectionKey, handlerFn := range sections {
-			childSection := child.Object.Find(sectionKey)
-			if childSection == nil {
-				continue
-			}
-
-			handlerFn(sectionKey, parentDoc.Path, parentDoc.Object, child.Path, childSection)
-			child.Object.Members = removeMember(child.Object, sectionKey)
-		}
-
-		for _, remainingSection := range child.Object.Members {
-			return fmt.Errorf("unsupported section [\"%s\"] in file [%s]", remainingSection.Key, child.Path)
+	if *sourceMapPath != "" {
+		err = writeSourceMap(*sourceMapPath, sources, parentDoc.Object, formatted)
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
 
-	for _, section := range parentDoc.Object.Members {
-		switch v := section.Value.(type) {
-		case *jwcc.Object:
-			sortMembersBySource(v)
-			dedupeCommentsInObject(v)
-		case *jwcc.Array:
-			dedupeCommentsInArray(v)
+	if *checkPath != "" {
+		diff, err := checkFile(*checkPath, formatted)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}
-
-	parentDoc.Object.Sort()
-
-	return nil
-}
-
-func sortMembersBySource(obj *jwcc.Object) {
-	sort.SliceStable(obj.Members, func(i, j int) bool {
-		commentsI := strings.Join(obj.Members[i].Comments().Before, "\n")
-		commentsJ := strings.Join(obj.Members[j].Comments().Before, "\n")
-		return commentsI < commentsJ
-	})
-}
-
-func commentsEqual(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
-	}
-	for i := range a {
-		if a[i] != b[i] {
-			return false
+		if diff != "" {
+			fmt.Fprintf(os.Stderr, "generated output does not match [%s]\n", *checkPath)
+			fmt.Print(diff)
+			os.Exit(1)
 		}
-	}
-	return true
-}
-
-func dedupeCommentsInObject(obj *jwcc.Object) {
-	var lastComments []string
-	for _, member := range obj.Members {
-		currentComments := member.Comments().Before
-
-		if commentsEqual(lastComments, currentComments) {
-			member.Comments().Before = nil
+		logVerbose("generated output matches [%s]\n", *checkPath)
+		if *outFile == "" {
+			return
 		}
-		lastComments = currentComments
+	}
 
-		switch v := member.Value.(type) {
-		case *jwcc.Array:
-			dedupeCommentsInArray(v)
-		case *jwcc.Object:
-			dedupeCommentsInObject(v)
-		}
+	err = outputFile(formatted)
+	if err != nil {
+		log.Fatal(err)
 	}
 }
 
-func dedupeCommentsInArray(arr *jwcc.Array) {
-	var lastComments []string
-	for _, val := range arr.Values {
-		var currentComments []string
-		switch v := val.(type) {
-		case *jwcc.Object:
-			currentComments = v.Comments().Before
-			if commentsEqual(lastComments, currentComments) {
-				v.Comments().Before = nil
-			}
-			lastComments = currentComments
-			dedupeCommentsInObject(v)
-		case *jwcc.Array:
-			currentComments = v.Comments().Before
-			if commentsEqual(lastComments, currentComments) {
-				v.Comments().Before = nil
-			}
-			lastComments = currentComments
-			dedupeCommentsInArray(v)
-		default:
-			if c, ok := val.(interface{ Comments() *jwcc.Comments }); ok {
-				currentComments = c.Comments().Before
-				if commentsEqual(lastComments, currentComments) {
-					c.Comments().Before = nil
-				}
-				lastComments = currentComments
-			}
+// getRegistry returns the handlers for every supported section, using the
+// given conflict strategies.
+func getRegistry(strategies conflictStrategies) (combiner.Registry, error) {
+	registry := combiner.DefaultRegistry()
+	for section, strategy := range strategies {
+		err := registry.SetConflictStrategy(section, strategy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid [-conflict] flag: %v", err)
 		}
+		logVerbose("using conflict strategy [%s] for section [%s]\n", strategy, section)
 	}
+	return registry, nil
 }
 
-func gatherChildren(path string) ([]*ParsedDocument, error) {
-	children := []*ParsedDocument{}
-
-	logVerbose(fmt.Sprintf("walking path [%v]...\n", path))
-	err := filepath.WalkDir(
-		*inChildDir,
-		func(path string, info fs.DirEntry, err error) error {
+func getPathRules(pathRules []allowPathRule, registry combiner.Registry) ([]combiner.PathRule, error) {
+	rules := []combiner.PathRule{}
+	for _, r := range pathRules {
+		rule := combiner.PathRule{Pattern: r.Pattern, Tags: r.Tags, Groups: r.Groups}
+		if len(r.Sections) > 0 {
+			sections, err := registry.Allow(r.Sections)
 			if err != nil {
-				return err
-			}
-
-			if info.IsDir() {
-				return nil
-			}
-
-			if !strings.HasSuffix(path, ".json") && !strings.HasSuffix(path, ".hujson") {
-				return nil
+				return nil, fmt.Errorf("invalid path rule [%s]: %v", r, err)
 			}
-
-			doc, err := parse(path)
+			rule.Sections = sections
+		}
+		for _, p := range r.Prefixes {
+			prefix, err := netip.ParsePrefix(p)
 			if err != nil {
-				log.Fatal(err)
+				return nil, fmt.Errorf("invalid path rule [%s]: %v", r, err)
 			}
-
-			children = append(children, doc)
-			return nil
-		},
-	)
-	if err != nil {
-		return nil, err
+			rule.Prefixes = append(rule.Prefixes, prefix)
+		}
+		rules = append(rules, rule)
 	}
-
-	return children, nil
+	return rules, nil
 }
 
-func outputFile(doc *jwcc.Object) error {
-	var sb strings.Builder
-	err := jwcc.Format(&sb, doc)
+// reportDiagnostics writes diags to stderr in the -diagnostics-format, exiting
+// if any of them is an error.
+func reportDiagnostics(diags combiner.Diagnostics) {
+	diags.Sort()
+	err := writeDiagnostics(os.Stderr, *diagnosticsFormat, diags)
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
-
-	formatted, err := hujson.Format([]byte(sb.String()))
-	if err != nil {
-		return err
+	if diags.HasErrors() {
+		os.Exit(1)
 	}
+}
 
+func outputFile(formatted []byte) error {
 	if *outFile != "" {
 		f, err := os.Create(*outFile)
 		if err != nil {
@@ -479,60 +472,6 @@ func outputFile(doc *jwcc.Object) error {
 	return nil
 }
 
-func parse(path string) (*ParsedDocument, error) {
-	logVerbose(fmt.Sprintf("parsing [%v]...\n", path))
-
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	doc, err := jwcc.Parse(f)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing %s: %v", path, err)
-	}
-
-	root, ok := doc.Value.(*jwcc.Object)
-	if !ok {
-		return nil, fmt.Errorf("invalid file format: document root is [%T], expected [object]", doc.Value)
-	}
-
-	return &ParsedDocument{Path: path, Object: root}, nil
-}
-
-func existingOrNewArray(doc jwcc.Object, key string) *jwcc.Array { // TODO: combine with existingOrNewObject and pass in type?
-	existingSection := doc.FindKey(ast.TextEqual(key))
-	if existingSection == nil {
-		logVerbose("section [%s] not found in parent doc, creating new array\n", key)
-		return new(jwcc.Array)
-	}
-	logVerbose("section [%s] found in parent doc, re-using array\n", key)
-	return existingSection.Value.(*jwcc.Array)
-}
-
-func existingOrNewObject(doc jwcc.Object, key string) *jwcc.Object {
-	existingSection := doc.FindKey(ast.TextEqual(key))
-	if existingSection == nil {
-		logVerbose("section [%s] not found in parent doc, creating new object\n", key)
-		return new(jwcc.Object)
-	}
-	logVerbose("section [%s] found in parent doc, re-using object\n", key)
-	return existingSection.Value.(*jwcc.Object)
-}
-
-func removeMember(obj *jwcc.Object, key string) []*jwcc.Member {
-	indexKey := obj.IndexKey(ast.TextEqual(key))
-
-	if indexKey == -1 {
-		return obj.Members
-	}
-
-	ret := make([]*jwcc.Member, 0)
-	ret = append(ret, obj.Members[:indexKey]...)
-	return append(ret, obj.Members[indexKey+1:]...)
-}
-
 func logVerbose(message string, a ...any) {
 	if *verbose {
 		os.Stderr.WriteString(fmt.Sprintf(message, a...))
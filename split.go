@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// splitRules is the -rule flag of split.
+type splitRules []combiner.SplitRule
+
+func (r *splitRules) String() string {
+	return fmt.Sprintf("%v", *r)
+}
+
+// Set adds a rule written as path=pattern,pattern. A path that isn't a .json
+// or .hujson file is a directory, and entries go to policy.hujson in it.
+func (r *splitRules) Set(value string) error {
+	filePath, patterns, ok := strings.Cut(value, "=")
+	if !ok || filePath == "" || patterns == "" {
+		return fmt.Errorf("invalid rule [%s], expected [path=tag:prefix*,group:prefix*]", value)
+	}
+	filePath = path.Clean(filepath.ToSlash(filePath))
+	if !combiner.IsLocalPath(filePath) {
+		return fmt.Errorf("invalid rule [%s], the path must be relative to -o and without [..]", value)
+	}
+	if !strings.HasSuffix(filePath, ".json") && !strings.HasSuffix(filePath, ".hujson") {
+		filePath = path.Join(filePath, "policy.hujson")
+	}
+	for _, pattern := range strings.Split(patterns, ",") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern [%s] for rule [%s]: %v", pattern, filePath, err)
+		}
+	}
+	*r = append(*r, combiner.SplitRule{Path: filePath, Patterns: strings.Split(patterns, ",")})
+	return nil
+}
+
+// runSplit writes a policy as a parent and child files, placing entries by
+// -rule or by their provenance comments, after checking that combining the
+// files gives the same policy.
+func runSplit(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("split", flag.ContinueOnError)
+	parentPath := flags.String("parent", "policy-parent.hujson", "path of the parent file to write, entries from it or from no other file stay in it")
+	sourceParentPath := flags.String("source-parent", "", "path of the parent in the policy's provenance comments, found from the sections only the parent sets if not given")
+	outDir := flags.String("o", ".", "directory to write the parent and child files to")
+	force := flags.Bool("force", false, "overwrite files that already exist")
+	var rules splitRules
+	flags.Var(&rules, "rule", "child file, or directory for a policy.hujson, to move entries using names matching patterns to - e.g. -rule=departments/finance=tag:finance*,group:finance* (may be repeated, the last matching rule wins)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: tailscale-acl-combiner split [-parent <file>] [-source-parent <file>] [-rule <path>=<patterns>] [-o <dir>] <policy>\n")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("missing argument <policy> - the policy file to split must be provided")
+	}
+
+	doc, err := combiner.Parse(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	parent, children, err := combiner.Split(doc.Object, combiner.SplitOptions{
+		ParentPath:       *parentPath,
+		SourceParentPath: *sourceParentPath,
+		Rules:            rules,
+		Warnf: func(format string, args ...any) {
+			fmt.Fprintf(w, "warning: "+format, args...)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	docs := append([]*combiner.ParsedDocument{parent}, children...)
+	if !*force {
+		for _, d := range docs {
+			if _, err := os.Stat(filepath.Join(*outDir, d.Path)); err == nil {
+				return fmt.Errorf("file [%s] already exists, use -force to overwrite it", filepath.Join(*outDir, d.Path))
+			}
+		}
+	}
+
+	for _, d := range docs {
+		formatted, err := combiner.Format(d.Object)
+		if err != nil {
+			return err
+		}
+		filePath := filepath.Join(*outDir, d.Path)
+		err = os.MkdirAll(filepath.Dir(filePath), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filePath, formatted, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "wrote %s\n", filePath)
+	}
+
+	if len(children) > 0 {
+		fmt.Fprintf(w, "combine with: tailscale-acl-combiner -f %s -d %s -allow=%s\n",
+			filepath.Join(*outDir, parent.Path), filepath.Join(*outDir, childDir(children)), strings.Join(childSections(children), ","))
+	}
+	return nil
+}
+
+// childDir returns the deepest directory containing every child file.
+func childDir(children []*combiner.ParsedDocument) string {
+	dir := path.Dir(filepath.ToSlash(children[0].Path))
+	for _, child := range children[1:] {
+		childPath := filepath.ToSlash(child.Path)
+		for dir != "." && dir != "/" && !strings.HasPrefix(childPath, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// childSections returns the sections used by the child files, sorted. Split
+// only puts entries moved by a rule or from a child's provenance in them, so
+// these are the sections children must be allowed.
+func childSections(children []*combiner.ParsedDocument) []string {
+	sections := []string{}
+	for _, child := range children {
+		for _, m := range child.Object.Members {
+			if !slices.Contains(sections, m.Key.String()) {
+				sections = append(sections, m.Key.String())
+			}
+		}
+	}
+	sort.Strings(sections)
+	return sections
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSplit(t *testing.T) {
+	dir := t.TempDir()
+	policyPath := filepath.Join(dir, "policy.hujson")
+	err := os.WriteFile(policyPath, []byte(`{
+		"groups": {
+			"group:eng":     ["alice@example.com"],
+			"group:finance": ["bob@example.com"],
+		},
+		"acls": [
+			{"action": "accept", "src": ["group:eng"], "dst": ["tag:server:22"]},
+			{"action": "accept", "src": ["group:finance"], "dst": ["tag:finance:443"]},
+		],
+	}`), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	args := []string{"-o", outDir, "-rule", "departments/finance=tag:finance*,group:finance", policyPath}
+	var sb strings.Builder
+	err = runSplit(args, &sb)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	expected := "wrote " + filepath.Join(outDir, "policy-parent.hujson") + "\n" +
+		"wrote " + filepath.Join(outDir, "departments/finance/policy.hujson") + "\n" +
+		"combine with: tailscale-acl-combiner -f " + filepath.Join(outDir, "policy-parent.hujson") + " -d " + filepath.Join(outDir, "departments/finance") + " -allow=acls,groups\n"
+	if sb.String() != expected {
+		t.Fatalf("expected [%s], got [%s]", expected, sb.String())
+	}
+
+	child, err := os.ReadFile(filepath.Join(outDir, "departments/finance/policy.hujson"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if !strings.Contains(string(child), "tag:finance:443") || strings.Contains(string(child), "group:eng") {
+		t.Fatalf("expected only the finance entries in the child file, got [%s]", child)
+	}
+
+	err = runSplit(args, &sb)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an error for existing files, got [%v]", err)
+	}
+}
+
+func TestSplitRulesRejectPathsOutsideOutput(t *testing.T) {
+	for _, value := range []string{"../escaped=tag:finance*", "/etc=tag:finance*"} {
+		var rules splitRules
+		err := rules.Set(value)
+		if err == nil {
+			t.Fatalf("expected an error for [%s], got [%v]", value, err)
+		}
+	}
+}
+
+func TestRunSplitCombinedPolicy(t *testing.T) {
+	outDir := t.TempDir()
+	var sb strings.Builder
+	err := runSplit([]string{"-o", outDir, "testdata/output-file-to-compare-to.hujson"}, &sb)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outDir, "testdata/input-parent.hujson")); err == nil {
+		t.Fatalf("expected the original parent's entries to be written to the parent, got [%s]", sb.String())
+	}
+	expected := " -allow=acls,autoApprovers,grants,groups,ipsets,ssh,sshTests,tests\n"
+	if !strings.HasSuffix(sb.String(), expected) {
+		t.Fatalf("expected only the sections from children to be allowed, got [%s]", sb.String())
+	}
+}